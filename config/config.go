@@ -0,0 +1,686 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"pisa/dhcp"
+	"pisa/util"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Configuration file used when none is given.
+const DefaultPath = "pisa.toml"
+
+// File the process id is written to when none is configured.
+const DefaultPidFile = "pisa.pid"
+
+// Settings read from the configuration file.
+type Config struct {
+	// Settings of the server and the relay agent.
+	Options dhcp.DHCPOptions
+
+	// Networks served, each with its own pools and options.
+	Subnets []*dhcp.Subnet
+
+	// Hosts with fixed addresses.
+	Reservations []*dhcp.Reservation
+}
+
+// Problem found in the configuration file.
+type Error struct {
+	// File the problem is in, empty when parsing data that didn't come from a file.
+	Path string
+	// Line the problem is on, zero if it concerns the whole file.
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Path != "" && e.Line > 0:
+		return fmt.Sprintf("%s:%d: %s", e.Path, e.Line, e.Message)
+	case e.Path != "":
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	case e.Line > 0:
+		return fmt.Sprintf("line %d: %s", e.Line, e.Message)
+	}
+	return e.Message
+}
+
+// Every problem found in the configuration file, ordered by line.
+type Errors []*Error
+
+func (e Errors) Error() string {
+	lines := make([]string, len(e))
+	for i, err := range e {
+		lines[i] = err.Error()
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Reads and validates a configuration file.
+//
+// Returns Errors listing every problem found.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config, err := Parse(data)
+	if errs, ok := err.(Errors); ok {
+		for _, e := range errs {
+			e.Path = path
+		}
+	}
+	return config, err
+}
+
+// Parses and validates a configuration.
+//
+// Returns Errors listing every problem found.
+func Parse(data []byte) (*Config, error) {
+	root, errs := parse(data)
+	d := &decoder{errs: errs}
+	config := d.config(root)
+	if len(d.errs) > 0 {
+		sort.SliceStable(d.errs, func(i, j int) bool {
+			return d.errs[i].Line < d.errs[j].Line
+		})
+		return nil, d.errs
+	}
+	return config, nil
+}
+
+// Checks tables against the schema and turns them into settings, collecting every problem on the way.
+type decoder struct {
+	errs Errors
+
+	// Options defined in the configuration, by name.
+	definitions map[string]dhcp.OptionDefinition
+}
+
+func (d *decoder) errorf(line int, format string, args ...any) {
+	d.errs = append(d.errs, &Error{Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// Reports names in a table that aren't part of the schema.
+func (d *decoder) known(t *Table, where string, names ...string) {
+	for _, key := range t.keys {
+		if !slices.Contains(names, key) {
+			d.errorf(t.line(key), "unknown setting %s in %s", key, where)
+		}
+	}
+}
+
+// Returns the value of a key.
+func (d *decoder) value(t *Table, key string) (*Value, bool) {
+	v, ok := t.Values[key]
+	if !ok && t.has(key) {
+		d.errorf(t.line(key), "%s must be a value, not a table", key)
+	}
+	return v, ok
+}
+
+// Returns a string value.
+func (d *decoder) string(t *Table, key string) (string, bool) {
+	v, ok := d.value(t, key)
+	if !ok {
+		return "", false
+	}
+	s, ok := v.Data.(string)
+	if !ok {
+		d.errorf(v.Line, "%s must be a string", key)
+	}
+	return s, ok
+}
+
+// Returns an unsigned integer value that fits into 32 bits.
+func (d *decoder) uint(t *Table, key string) (uint, bool) {
+	v, ok := d.value(t, key)
+	if !ok {
+		return 0, false
+	}
+	n, ok := v.Data.(int64)
+	if !ok || n < 0 || n > math.MaxUint32 {
+		d.errorf(v.Line, "%s must be a number between 0 and %d", key, uint32(math.MaxUint32))
+		return 0, false
+	}
+	return uint(n), true
+}
+
+// Returns a boolean value.
+func (d *decoder) bool(t *Table, key string) (bool, bool) {
+	v, ok := d.value(t, key)
+	if !ok {
+		return false, false
+	}
+	b, ok := v.Data.(bool)
+	if !ok {
+		d.errorf(v.Line, "%s must be true or false", key)
+	}
+	return b, ok
+}
+
+// Returns an array of strings, a single string is taken as an array of one.
+func (d *decoder) strings(t *Table, key string) ([]string, bool) {
+	v, ok := d.value(t, key)
+	if !ok {
+		return nil, false
+	}
+	if s, ok := v.Data.(string); ok {
+		return []string{s}, true
+	}
+
+	elements, ok := v.Data.([]*Value)
+	if !ok || len(elements) == 0 {
+		d.errorf(v.Line, "%s must be a string or a list of strings", key)
+		return nil, false
+	}
+	list := make([]string, 0, len(elements))
+	for _, element := range elements {
+		s, ok := element.Data.(string)
+		if !ok {
+			d.errorf(element.Line, "%s must be a list of strings", key)
+			return nil, false
+		}
+		list = append(list, s)
+	}
+	return list, true
+}
+
+// Returns an IPv4 address.
+func (d *decoder) address(t *Table, key string) (string, bool) {
+	addr, ok := d.string(t, key)
+	if ok && !util.CheckAddress(addr) {
+		d.errorf(t.line(key), "invalid address %q in %s", addr, key)
+		return "", false
+	}
+	return addr, ok
+}
+
+// Returns a list of IPv4 addresses.
+func (d *decoder) addresses(t *Table, key string) ([]string, bool) {
+	addrs, ok := d.strings(t, key)
+	if !ok {
+		return nil, false
+	}
+	for _, addr := range addrs {
+		if !util.CheckAddress(addr) {
+			d.errorf(t.line(key), "invalid address %q in %s", addr, key)
+			ok = false
+		}
+	}
+	return addrs, ok
+}
+
+// Returns a sub-table.
+func (d *decoder) table(t *Table, key string) (*Table, bool) {
+	sub, ok := t.Tables[key]
+	if !ok && t.has(key) {
+		d.errorf(t.line(key), "%s must be a table ([%s])", key, key)
+	}
+	return sub, ok
+}
+
+// Returns an array of tables.
+func (d *decoder) tables(t *Table, key string) []*Table {
+	if _, ok := t.Arrays[key]; !ok && t.has(key) {
+		d.errorf(t.line(key), "%s must be an array of tables ([[%s]])", key, key)
+	}
+	return t.Arrays[key]
+}
+
+// Decodes the whole configuration.
+func (d *decoder) config(root *Table) *Config {
+	d.known(root, "the configuration",
+		"interface", "mode", "leasefile", "pidfile", "grace", "probation", "authoritative", "relay", "option", "subnet", "reservation")
+
+	c := &Config{}
+	opt := &c.Options
+
+	// Custom options have to be known before the option tables use them.
+	d.definitions = make(map[string]dhcp.OptionDefinition)
+	for _, t := range d.tables(root, "option") {
+		d.definition(t)
+	}
+
+	opt.Mode = dhcp.ModeServer
+	if mode, ok := d.string(root, "mode"); ok {
+		if mode != dhcp.ModeServer && mode != dhcp.ModeRelay {
+			d.errorf(root.line("mode"), "unknown mode %q, must be %s or %s", mode, dhcp.ModeServer, dhcp.ModeRelay)
+		}
+		opt.Mode = mode
+	}
+
+	// The relay agent listens on several client interfaces.
+	if interfaces, ok := d.strings(root, "interface"); ok {
+		if len(interfaces) > 1 && opt.Mode != dhcp.ModeRelay {
+			d.errorf(root.line("interface"), "only the relay agent can use several interfaces")
+		}
+		opt.Interface = strings.Join(interfaces, ",")
+	} else if !root.has("interface") {
+		d.errorf(0, "no interface provided")
+	}
+
+	if leaseFile, ok := d.string(root, "leasefile"); ok {
+		opt.LeaseFile = leaseFile
+	}
+	opt.PidFile = DefaultPidFile
+	if pidFile, ok := d.string(root, "pidfile"); ok {
+		opt.PidFile = pidFile
+	}
+	if grace, ok := d.uint(root, "grace"); ok {
+		opt.Grace = grace
+	}
+	if probation, ok := d.uint(root, "probation"); ok {
+		opt.Probation = probation
+	}
+	if authoritative, ok := d.bool(root, "authoritative"); ok {
+		opt.Authoritative = authoritative
+	}
+
+	relay, hasRelay := d.table(root, "relay")
+	if hasRelay {
+		d.relay(relay, opt)
+	}
+	if opt.Mode == dhcp.ModeRelay && len(opt.Upstream) == 0 && (!hasRelay || !relay.has("upstream")) {
+		d.errorf(root.line("mode"), "relay mode needs upstream servers in [relay]")
+	}
+
+	// Only one subnet can stand for the network of the interface.
+	implicit := 0
+	lines := make(map[*dhcp.Subnet]int)
+	for _, t := range d.tables(root, "subnet") {
+		subnet := d.subnet(t)
+		if subnet == nil {
+			continue
+		}
+		if subnet.Mask == 0 {
+			implicit++
+			if implicit > 1 {
+				d.errorf(t.Line, "only one subnet can leave out its network")
+			}
+		} else {
+			d.overlappingSubnet(t, subnet, c.Subnets, lines)
+			lines[subnet] = t.line("network")
+		}
+		c.Subnets = append(c.Subnets, subnet)
+	}
+	if opt.Mode == dhcp.ModeServer && !root.has("subnet") {
+		d.errorf(0, "no subnet provided, add a [[subnet]]")
+	}
+
+	reserved := make(map[uint32]int)
+	for _, t := range d.tables(root, "reservation") {
+		r := d.reservation(t, c.Subnets)
+		if r == nil {
+			continue
+		}
+		if line, ok := reserved[r.Address]; ok {
+			d.errorf(t.Line, "address %s is already reserved on line %d", util.Uint32IntoAddress(r.Address), line)
+		}
+		reserved[r.Address] = t.Line
+		c.Reservations = append(c.Reservations, r)
+	}
+
+	return c
+}
+
+// Decodes the [relay] table.
+func (d *decoder) relay(t *Table, opt *dhcp.DHCPOptions) {
+	d.known(t, "[relay]", "upstream", "relayinfo", "remoteid")
+	if upstream, ok := d.addresses(t, "upstream"); ok {
+		opt.Upstream = upstream
+	}
+	if relayInfo, ok := d.bool(t, "relayinfo"); ok {
+		opt.RelayAgentInfo = relayInfo
+	}
+	if remoteID, ok := d.string(t, "remoteid"); ok {
+		opt.RemoteID = remoteID
+	}
+}
+
+// Decodes a [[subnet]] with its pools and options.
+func (d *decoder) subnet(t *Table) *dhcp.Subnet {
+	d.known(t, "[[subnet]]", "network", "lease", "allocator", "identity", "options", "pool")
+
+	opt := &dhcp.SubnetOptions{}
+	if lease, ok := d.uint(t, "lease"); ok {
+		opt.Lease = lease
+	}
+	if allocator, ok := d.string(t, "allocator"); ok && d.allocator(t, allocator) {
+		opt.Allocator = allocator
+	}
+	if identity, ok := d.string(t, "identity"); ok && d.identity(t, identity) {
+		opt.Identity = identity
+	}
+
+	var names []string
+	if options, ok := d.table(t, "options"); ok {
+		names = d.options(options, opt)
+	}
+
+	network, _ := d.string(t, "network")
+	subnet, err := dhcp.NewSubnet(network, opt, names)
+	if err != nil {
+		d.errorf(t.line("network"), "%v", err)
+		return nil
+	}
+
+	pools := d.tables(t, "pool")
+	if len(pools) == 0 {
+		d.errorf(t.Line, "subnet has no pools, add a [[subnet.pool]]")
+	}
+	for _, pool := range pools {
+		d.pool(pool, subnet)
+	}
+	return subnet
+}
+
+// Reports a subnet whose network overlaps one of the subnets before it, lines are the
+// lines their networks are on.
+func (d *decoder) overlappingSubnet(t *Table, subnet *dhcp.Subnet, subnets []*dhcp.Subnet, lines map[*dhcp.Subnet]int) {
+	for _, other := range subnets {
+		switch {
+		case other.Mask == 0:
+			continue
+		case other.String() == subnet.String():
+			d.errorf(t.line("network"), "subnet %s is already defined on line %d", subnet, lines[other])
+		case subnet.Overlaps(other):
+			d.errorf(t.line("network"), "subnet %s overlaps %s on line %d", subnet, other, lines[other])
+		}
+	}
+}
+
+// Checks an allocation strategy.
+func (d *decoder) allocator(t *Table, allocator string) bool {
+	if _, err := dhcp.NewAllocator(allocator, 0, 0); err != nil {
+		d.errorf(t.line("allocator"), "%v", err)
+		return false
+	}
+	return true
+}
+
+// Checks an identity policy.
+func (d *decoder) identity(t *Table, identity string) bool {
+	if identity != dhcp.IdentityClientID && identity != dhcp.IdentityMAC {
+		d.errorf(t.line("identity"), "unknown identity policy %q, must be %s or %s", identity, dhcp.IdentityClientID, dhcp.IdentityMAC)
+		return false
+	}
+	return true
+}
+
+// Decodes a [[subnet.pool]] and adds it to its subnet.
+//
+// Allocator and identity default to the ones of the subnet.
+func (d *decoder) pool(t *Table, subnet *dhcp.Subnet) {
+	d.known(t, "[[subnet.pool]]", "range", "allocator", "identity", "circuit", "remote")
+
+	allocator, identity := subnet.Options.Allocator, subnet.Options.Identity
+	if s, ok := d.string(t, "allocator"); ok && d.allocator(t, s) {
+		allocator = s
+	}
+	if s, ok := d.string(t, "identity"); ok && d.identity(t, s) {
+		identity = s
+	}
+
+	r, ok := d.string(t, "range")
+	if !ok {
+		if !t.has("range") {
+			d.errorf(t.Line, "pool has no range")
+		}
+		return
+	}
+	first, last, ok := strings.Cut(r, "-")
+	first, last = strings.TrimSpace(first), strings.TrimSpace(last)
+	if !ok || !util.CheckAddress(first) || !util.CheckAddress(last) {
+		d.errorf(t.line("range"), "invalid range %q, must be first-last", r)
+		return
+	}
+
+	pool, err := dhcp.NewPool(util.AddressIntoUint32(first), util.AddressIntoUint32(last), allocator, identity)
+	if err != nil {
+		d.errorf(t.line("range"), "%v", err)
+		return
+	}
+	if subnet.Mask != 0 && (!subnet.Contains(pool.First) || !subnet.Contains(pool.Last)) {
+		d.errorf(t.line("range"), "range %s is outside of subnet %s", r, subnet)
+	} else if broadcast := subnet.Network | ^subnet.Mask; subnet.Mask != 0 && ^subnet.Mask > 1 &&
+		(pool.First == subnet.Network || pool.Last == broadcast) {
+		// /31 and /32 networks have no network and broadcast addresses (RFC 3021).
+		d.errorf(t.line("range"), "range %s includes the network or broadcast address of subnet %s", r, subnet)
+	}
+	for _, other := range subnet.Pools {
+		if pool.First <= other.Last && other.First <= pool.Last {
+			d.errorf(t.line("range"), "range %s overlaps %s-%s", r,
+				util.Uint32IntoAddress(other.First), util.Uint32IntoAddress(other.Last))
+		}
+	}
+
+	pool.CircuitID = d.relayID(t, "circuit")
+	pool.RemoteID = d.relayID(t, "remote")
+	subnet.Pools = append(subnet.Pools, pool)
+}
+
+// Returns a Circuit-ID or Remote-ID.
+func (d *decoder) relayID(t *Table, key string) []byte {
+	value, ok := d.string(t, key)
+	if !ok {
+		return nil
+	}
+	id, err := dhcp.ParseRelayID(value)
+	if err != nil {
+		d.errorf(t.line(key), "%v", err)
+	}
+	return id
+}
+
+// Decodes an [[option]] defining a custom option.
+func (d *decoder) definition(t *Table) {
+	d.known(t, "[[option]]", "name", "code", "type")
+
+	name, hasName := d.string(t, "name")
+	code, hasCode := d.uint(t, "code")
+	typ, hasType := d.string(t, "type")
+	if !hasName || !hasCode || !hasType {
+		if !t.has("name") || !t.has("code") || !t.has("type") {
+			d.errorf(t.Line, "option needs a name, code and type")
+		}
+		return
+	}
+	if code > 255 {
+		d.errorf(t.line("code"), "option code %d is above 255", code)
+		return
+	}
+
+	def := dhcp.OptionDefinition{Name: name, Code: uint8(code), Type: typ}
+	if err := def.Validate(); err != nil {
+		d.errorf(t.Line, "%v", err)
+		return
+	}
+	_, standard := dhcp.LookupOption(name)
+	_, defined := d.definitions[name]
+	if standard || defined || slices.Contains(optionKeys, name) {
+		d.errorf(t.line("name"), "option %s is already defined", name)
+		return
+	}
+	d.definitions[name] = def
+}
+
+// Returns a custom or standard option by name.
+func (d *decoder) lookupOption(name string) (dhcp.OptionDefinition, bool) {
+	if def, ok := d.definitions[name]; ok {
+		return def, true
+	}
+	return dhcp.LookupOption(name)
+}
+
+// Keys of an options table that have fields of their own, custom options can't use them.
+var optionKeys = []string{"router", "subnetmask", "dns", "timesvr", "routes"}
+
+// Decodes an options table, returns the names of the options set.
+//
+// Options other than the ones with fields of their own are encoded into Extra.
+func (d *decoder) options(t *Table, opt *dhcp.SubnetOptions) []string {
+	var names []string
+	for _, key := range t.keys {
+		var ok bool
+		switch key {
+		case "router":
+			opt.Router, ok = d.addresses(t, key)
+		case "subnetmask":
+			opt.SubnetMask, ok = d.address(t, key)
+		case "dns":
+			opt.DNS, ok = d.addresses(t, key)
+		case "timesvr":
+			opt.TimeServer, ok = d.addresses(t, key)
+		case "routes":
+			opt.Routes, ok = d.routes(t, key)
+		default:
+			d.extraOption(t, key, opt)
+			continue
+		}
+		if ok {
+			names = append(names, key)
+		}
+	}
+
+	// Clients that get classless routes ignore the router option.
+	for _, key := range []string{"routes", "classless-routes", "ms-classless-routes"} {
+		if !t.has(key) || !t.has("router") {
+			continue
+		}
+		if routes, ok := d.optionValues(t, key); ok && !dhcp.HasDefaultRoute(routes) {
+			d.errorf(t.line(key), "%s needs a default route (0.0.0.0/0) as clients ignore router when they get classless routes", key)
+		}
+	}
+	if t.has("routes") && (t.has("classless-routes") || t.has("ms-classless-routes")) {
+		d.errorf(t.line("routes"), "routes already sets classless-routes and ms-classless-routes")
+	}
+	return names
+}
+
+// Returns classless static routes.
+func (d *decoder) routes(t *Table, key string) ([]string, bool) {
+	routes, ok := d.strings(t, key)
+	if !ok {
+		return nil, false
+	}
+	if _, err := dhcp.EncodeRoutes(routes); err != nil {
+		d.errorf(t.line(key), "%v", err)
+		return nil, false
+	}
+	return routes, true
+}
+
+// Encodes an option that is looked up by name.
+func (d *decoder) extraOption(t *Table, key string, opt *dhcp.SubnetOptions) {
+	def, ok := d.lookupOption(key)
+	if !ok {
+		d.errorf(t.line(key), "unknown option %s, define it with [[option]]", key)
+		return
+	}
+	values, ok := d.optionValues(t, key)
+	if !ok {
+		return
+	}
+	data, err := def.Encode(values)
+	if err != nil {
+		d.errorf(t.line(key), "%v", err)
+		return
+	}
+	opt.Extra.Set(def.Code, data)
+}
+
+// Returns the value of an option as text, a single value or an array of them.
+func (d *decoder) optionValues(t *Table, key string) ([]string, bool) {
+	v, ok := d.value(t, key)
+	if !ok {
+		return nil, false
+	}
+	elements, isArray := v.Data.([]*Value)
+	if !isArray {
+		elements = []*Value{v}
+	}
+
+	values := make([]string, 0, len(elements))
+	for _, element := range elements {
+		switch data := element.Data.(type) {
+		case string:
+			values = append(values, data)
+		case int64:
+			values = append(values, strconv.FormatInt(data, 10))
+		case bool:
+			values = append(values, strconv.FormatBool(data))
+		default:
+			d.errorf(element.Line, "%s can't hold nested arrays", key)
+			return nil, false
+		}
+	}
+	return values, true
+}
+
+// Decodes a [[reservation]], subnets are the ones it can be in.
+func (d *decoder) reservation(t *Table, subnets []*dhcp.Subnet) *dhcp.Reservation {
+	d.known(t, "[[reservation]]", "mac", "clientid", "circuit", "remote", "address", "hostname", "options")
+
+	r := &dhcp.Reservation{}
+	var err error
+	if mac, ok := d.string(t, "mac"); ok {
+		if r.MAC, err = dhcp.ParseMAC(mac); err != nil {
+			d.errorf(t.line("mac"), "%v", err)
+		}
+	}
+	if id, ok := d.string(t, "clientid"); ok {
+		if r.ClientID, err = dhcp.ParseClientID(id); err != nil {
+			d.errorf(t.line("clientid"), "%v", err)
+		}
+	}
+	if t.has("mac") && t.has("clientid") {
+		d.errorf(t.line("clientid"), "reservation can't match both mac and clientid")
+	}
+	r.CircuitID = d.relayID(t, "circuit")
+	r.RemoteID = d.relayID(t, "remote")
+	if !t.has("mac") && !t.has("clientid") && !t.has("circuit") && !t.has("remote") {
+		d.errorf(t.Line, "reservation needs a mac, clientid, circuit or remote")
+	}
+
+	address, ok := d.address(t, "address")
+	if !ok {
+		if !t.has("address") {
+			d.errorf(t.Line, "reservation has no address")
+		}
+		return nil
+	}
+	r.Address = util.AddressIntoUint32(address)
+	r.Hostname, _ = d.string(t, "hostname")
+
+	// Any address may be in the network of the interface, which isn't known yet.
+	if len(subnets) > 0 && !slices.ContainsFunc(subnets, func(subnet *dhcp.Subnet) bool {
+		return subnet.Mask == 0 || subnet.Contains(r.Address)
+	}) {
+		d.errorf(t.line("address"), "address %s is outside of every subnet", address)
+	}
+
+	if options, ok := d.table(t, "options"); ok {
+		var opt dhcp.SubnetOptions
+		names := d.options(options, &opt)
+		r.Options = dhcp.BuildOptions(&opt, names)
+
+		// Routes of the host replace the ones of its subnet, but not the router.
+		// The network of the interface isn't known yet and can't be checked.
+		for _, subnet := range subnets {
+			if subnet.Mask == 0 || !subnet.Contains(r.Address) {
+				continue
+			}
+			if len(opt.Routes) > 0 && len(subnet.Options.Router) > 0 && !dhcp.HasDefaultRoute(opt.Routes) {
+				d.errorf(options.line("routes"), "routes needs a default route (0.0.0.0/0) as subnet %s has a router", subnet)
+			}
+		}
+	}
+	return r
+}
@@ -0,0 +1,319 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"pisa/dhcp"
+	"pisa/util"
+	"strings"
+	"testing"
+)
+
+const validConfig = `
+# Interface the server listens on.
+interface = "eth0"
+mode = "server"
+leasefile = "test.leases"
+grace = 600
+authoritative = true
+
+[[option]]
+name = "tftp-servers"
+code = 150
+type = "ip-list"
+
+# Network of the interface.
+[[subnet]]
+lease = 2400
+allocator = "sequential"
+
+[subnet.options]
+subnetmask = "255.255.255.0"
+router = ["192.168.0.1"]
+
+[[subnet.pool]]
+range = "192.168.0.2-192.168.0.200"
+
+[[subnet]]
+network = "10.0.5.0/24"
+identity = "mac"
+
+[subnet.options]
+dns = [
+	"1.1.1.1", # Cloudflare
+	"8.8.8.8",
+]
+tftp-servers = "10.0.5.1"
+
+[[subnet.pool]]
+range = "10.0.5.10-10.0.5.99"
+allocator = "random"
+
+[[subnet.pool]]
+range = "10.0.5.100-10.0.5.199"
+circuit = "eth1/1"
+
+[[reservation]]
+mac = "00:11:22:33:44:55"
+address = "10.0.5.5"
+hostname = 'printer'
+`
+
+func TestParseValid(t *testing.T) {
+	c, err := Parse([]byte(validConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt := c.Options
+	if opt.Interface != "eth0" || opt.Mode != dhcp.ModeServer || opt.LeaseFile != "test.leases" ||
+		opt.Grace != 600 || !opt.Authoritative || opt.PidFile != DefaultPidFile {
+		t.Errorf("options = %+v", opt)
+	}
+
+	if len(c.Subnets) != 2 {
+		t.Fatalf("%d subnets, want 2", len(c.Subnets))
+	}
+	local, other := c.Subnets[0], c.Subnets[1]
+	if local.Mask != 0 || local.Options.Lease != 2400 || len(local.Pools) != 1 {
+		t.Errorf("first subnet = %+v", local)
+	}
+	if other.String() != "10.0.5.0/24" || other.Options.Identity != dhcp.IdentityMAC {
+		t.Errorf("second subnet = %s, identity %q", other, other.Options.Identity)
+	}
+
+	// Pools belong to the subnet above them.
+	if len(other.Pools) != 2 {
+		t.Fatalf("%d pools in the second subnet, want 2", len(other.Pools))
+	}
+	first, second := other.Pools[0], other.Pools[1]
+	if first.String() != "10.0.5.10-10.0.5.99" || first.Identity != dhcp.IdentityMAC {
+		t.Errorf("first pool = %s, identity %q", first, first.Identity)
+	}
+	if second.String() != "10.0.5.100-10.0.5.199" || string(second.CircuitID) != "eth1/1" {
+		t.Errorf("second pool = %s, circuit %q", second, second.CircuitID)
+	}
+
+	dns := other.Options.DNS
+	if len(dns) != 2 || dns[0] != "1.1.1.1" || dns[1] != "8.8.8.8" {
+		t.Errorf("dns = %v", dns)
+	}
+	if data, ok := other.Options.Extra.Get(150); !ok || !bytes.Equal(data, []byte{10, 0, 5, 1}) {
+		t.Errorf("custom option 150 = %v", data)
+	}
+
+	if len(c.Reservations) != 1 {
+		t.Fatalf("%d reservations, want 1", len(c.Reservations))
+	}
+	r := c.Reservations[0]
+	if r.MAC != "001122334455" || util.Uint32IntoAddress(r.Address) != "10.0.5.5" || r.Hostname != "printer" {
+		t.Errorf("reservation = %+v", r)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errs   []string
+	}{
+		{
+			name:   "missing interface",
+			config: "[[subnet]]\n[[subnet.pool]]\nrange = \"10.0.0.2-10.0.0.9\"\n",
+			errs:   []string{"no interface provided"},
+		},
+		{
+			name:   "syntax",
+			config: "interface = \"eth0\"\nname \"x\"\nx = \"abc\ny = [1 2]\nz = 1 2\n[subnet\n",
+			errs: []string{
+				"no subnet provided, add a [[subnet]]",
+				"line 2: missing = after name",
+				"line 3: unterminated string",
+				"line 4: missing , between array elements",
+				"line 5: unexpected '2' after value",
+				"line 5: unknown setting z in the configuration",
+				"line 6: missing ] after [subnet",
+			},
+		},
+		{
+			name:   "error inside a multi-line array",
+			config: "interface = [\"eth0\",\n  oops\n]\nmode = 5\n",
+			errs: []string{
+				"no interface provided",
+				"no subnet provided, add a [[subnet]]",
+				"line 2: invalid value",
+				"line 4: mode must be a string",
+			},
+		},
+		{
+			name:   "duplicate keys and tables",
+			config: "interface = \"eth0\"\ninterface = \"eth1\"\n[relay]\n[relay]\nmode = \"relay\"\nupstream = \"10.0.0.1\"\n",
+			errs: []string{
+				"no subnet provided, add a [[subnet]]",
+				"line 2: interface is already defined on line 1",
+				"line 4: table relay is already defined on line 3",
+				"line 5: unknown setting mode in [relay]",
+			},
+		},
+		{
+			name:   "table redefining a key",
+			config: "interface = \"eth0\"\nrelay = 1\n[relay]\n",
+			errs: []string{
+				"no subnet provided, add a [[subnet]]",
+				"line 2: relay must be a table ([relay])",
+				"line 3: relay is already defined on line 2",
+			},
+		},
+		{
+			name:   "no subnet",
+			config: "interface = \"eth0\"\n",
+			errs:   []string{"no subnet provided, add a [[subnet]]"},
+		},
+		{
+			name: "pools",
+			config: `interface = "eth0"
+[[subnet]]
+network = "10.0.0.0/24"
+[[subnet.pool]]
+range = "10.0.0.0-10.0.0.50"
+[[subnet.pool]]
+range = "10.0.0.200-10.0.0.255"
+[[subnet.pool]]
+range = "10.0.0.40-10.0.0.60"
+[[subnet.pool]]
+range = "10.0.1.1-10.0.1.9"
+[[subnet]]
+network = "10.0.9.0/24"
+`,
+			errs: []string{
+				"line 5: range 10.0.0.0-10.0.0.50 includes the network or broadcast address of subnet 10.0.0.0/24",
+				"line 7: range 10.0.0.200-10.0.0.255 includes the network or broadcast address of subnet 10.0.0.0/24",
+				"line 9: range 10.0.0.40-10.0.0.60 overlaps 10.0.0.0-10.0.0.50",
+				"line 11: range 10.0.1.1-10.0.1.9 is outside of subnet 10.0.0.0/24",
+				"line 12: subnet has no pools, add a [[subnet.pool]]",
+			},
+		},
+		{
+			name: "overlapping subnets",
+			config: `interface = "eth0"
+[[subnet]]
+network = "10.0.0.0/24"
+[[subnet.pool]]
+range = "10.0.0.10-10.0.0.20"
+[[subnet]]
+network = "10.0.0.0/24"
+[[subnet.pool]]
+range = "10.0.0.30-10.0.0.40"
+[[subnet]]
+network = "10.0.0.0/16"
+[[subnet.pool]]
+range = "10.0.1.10-10.0.1.20"
+[[subnet]]
+network = "10.1.0.0/16"
+[[subnet.pool]]
+range = "10.1.0.10-10.1.0.20"
+`,
+			errs: []string{
+				"line 7: subnet 10.0.0.0/24 is already defined on line 3",
+				"line 11: subnet 10.0.0.0/16 overlaps 10.0.0.0/24 on line 3",
+				"line 11: subnet 10.0.0.0/16 overlaps 10.0.0.0/24 on line 7",
+			},
+		},
+		{
+			name: "reservations",
+			config: `interface = "eth0"
+[[subnet]]
+network = "10.0.0.0/24"
+[[subnet.pool]]
+range = "10.0.0.10-10.0.0.20"
+[[reservation]]
+mac = "00:11:22:33:44:55"
+address = "192.168.7.7"
+[[reservation]]
+mac = "00:11:22:33:44:56"
+address = "10.0.0.5"
+[[reservation]]
+clientid = "01:00:11:22:33:44:57"
+address = "10.0.0.5"
+[[reservation]]
+address = "10.0.0.300"
+`,
+			errs: []string{
+				"line 8: address 192.168.7.7 is outside of every subnet",
+				"line 12: address 10.0.0.5 is already reserved on line 9",
+				"line 15: reservation needs a mac, clientid, circuit or remote",
+				"line 16: invalid address \"10.0.0.300\" in address",
+			},
+		},
+		{
+			name: "options",
+			config: `interface = "eth0"
+[[option]]
+name = "dns"
+code = 150
+type = "ip"
+[[option]]
+name = "tag"
+code = 151
+type = "text"
+[[option]]
+name = "relay-info"
+code = 82
+type = "hex"
+[[option]]
+name = "client-id"
+code = 61
+type = "hex"
+[[option]]
+name = "routes"
+code = 152
+type = "hex"
+[[subnet]]
+[subnet.options]
+router = "10.0.0.1"
+routes = ["10.1.0.0/16 via 10.0.0.2"]
+unknown = "x"
+[[subnet.pool]]
+range = "10.0.0.10-10.0.0.20"
+`,
+			errs: []string{
+				"line 3: option dns is already defined",
+				"line 6: unknown option type \"text\"",
+				"line 10: option 82 is managed by pisa",
+				"line 14: option 61 is managed by pisa",
+				"line 19: option routes is already defined",
+				"line 25: routes needs a default route (0.0.0.0/0) as clients ignore router when they get classless routes",
+				"line 26: unknown option unknown, define it with [[option]]",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.config))
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if want := strings.Join(tt.errs, "\n"); err.Error() != want {
+				t.Errorf("errors:\n%s\nwant:\n%s", err, want)
+			}
+		})
+	}
+}
+
+func TestLoadReportsPath(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pisa.toml")
+	if err := os.WriteFile(path, []byte("interface = \"eth0\"\nmode = \"client\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Load(path)
+	errs, ok := err.(Errors)
+	if !ok || len(errs) == 0 {
+		t.Fatalf("error = %v, want Errors", err)
+	}
+	want := path + ":2: unknown mode \"client\", must be server or relay"
+	if errs[0].Error() != want {
+		t.Errorf("error = %q, want %q", errs[0], want)
+	}
+}
@@ -0,0 +1,442 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Value of a key in the configuration file.
+type Value struct {
+	// Line the value starts on.
+	Line int
+	// string, int64, bool or []*Value
+	Data any
+}
+
+// Table of the configuration file, the file itself is the root table.
+type Table struct {
+	// Line of the table header.
+	Line int
+
+	Values map[string]*Value
+	Tables map[string]*Table
+	// Arrays of tables ([[name]]).
+	Arrays map[string][]*Table
+
+	// Names in the order they appear in the file.
+	keys []string
+	// Whether the table has a header of its own, [a.b] also creates a without one.
+	defined bool
+}
+
+func newTable(line int) *Table {
+	return &Table{
+		Line:   line,
+		Values: make(map[string]*Value),
+		Tables: make(map[string]*Table),
+		Arrays: make(map[string][]*Table),
+	}
+}
+
+// Returns the line a key, table or array of tables was defined on.
+func (t *Table) line(key string) int {
+	if v, ok := t.Values[key]; ok {
+		return v.Line
+	}
+	if sub, ok := t.Tables[key]; ok {
+		return sub.Line
+	}
+	if array := t.Arrays[key]; len(array) > 0 {
+		return array[0].Line
+	}
+	return t.Line
+}
+
+// Reports whether a name is used by the table.
+func (t *Table) has(key string) bool {
+	_, value := t.Values[key]
+	_, table := t.Tables[key]
+	_, array := t.Arrays[key]
+	return value || table || array
+}
+
+// Parser of the subset of TOML pisa uses for its configuration.
+//
+// Supported are comments, tables, arrays of tables and keys with strings,
+// integers, booleans and arrays as values. Arrays may span several lines.
+type parser struct {
+	data []byte
+	pos  int
+	line int
+
+	root    *Table
+	current *Table
+	errs    Errors
+}
+
+// Parses a configuration file into its root table.
+//
+// Parsing goes on after a broken line so every syntax error is reported.
+func parse(data []byte) (*Table, Errors) {
+	p := &parser{data: data, line: 1, root: newTable(0)}
+	p.current = p.root
+
+	for p.pos < len(p.data) {
+		p.skipSpace()
+		if p.pos >= len(p.data) {
+			break
+		}
+
+		var err error
+		switch p.data[p.pos] {
+		case '\n':
+			p.pos++
+			p.line++
+			continue
+		case '#':
+			p.skipLine()
+			continue
+		case '[':
+			err = p.header()
+		default:
+			err = p.keyValue()
+		}
+		if err == nil {
+			err = p.endOfLine()
+		}
+		if err != nil {
+			p.errs = append(p.errs, err.(*Error))
+			p.skipLine()
+		}
+	}
+	return p.root, p.errs
+}
+
+func (p *parser) errorf(format string, args ...any) *Error {
+	return &Error{Line: p.line, Message: fmt.Sprintf(format, args...)}
+}
+
+// Skips spaces and tabs.
+func (p *parser) skipSpace() {
+	for p.pos < len(p.data) && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t' || p.data[p.pos] == '\r') {
+		p.pos++
+	}
+}
+
+// Skips whitespace, newlines and comments, used inside arrays.
+func (p *parser) skipBlank() {
+	for p.pos < len(p.data) {
+		switch p.data[p.pos] {
+		case ' ', '\t', '\r':
+			p.pos++
+		case '\n':
+			p.pos++
+			p.line++
+		case '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// Skips the rest of the line, including the newline.
+func (p *parser) skipLine() {
+	for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+		p.pos++
+	}
+	if p.pos < len(p.data) {
+		p.pos++
+		p.line++
+	}
+}
+
+// Makes sure nothing but a comment follows on the line.
+func (p *parser) endOfLine() error {
+	p.skipSpace()
+	if p.pos >= len(p.data) || p.data[p.pos] == '\n' || p.data[p.pos] == '#' {
+		return nil
+	}
+	return p.errorf("unexpected %q after value", p.data[p.pos])
+}
+
+// Reads a bare key.
+func (p *parser) key() (string, error) {
+	start := p.pos
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-') {
+			break
+		}
+		p.pos++
+	}
+	if p.pos == start {
+		if p.pos >= len(p.data) || p.data[p.pos] == '\n' {
+			return "", p.errorf("missing key")
+		}
+		return "", p.errorf("invalid character %q in key", p.data[p.pos])
+	}
+	return string(p.data[start:p.pos]), nil
+}
+
+// Parses a [table] or [[array]] header and makes it the current table.
+func (p *parser) header() error {
+	line := p.line
+	p.pos++
+	array := p.pos < len(p.data) && p.data[p.pos] == '['
+	if array {
+		p.pos++
+	}
+
+	var path []string
+	for {
+		p.skipSpace()
+		name, err := p.key()
+		if err != nil {
+			return err
+		}
+		path = append(path, name)
+		p.skipSpace()
+		if p.pos < len(p.data) && p.data[p.pos] == '.' {
+			p.pos++
+			continue
+		}
+		break
+	}
+
+	closing := "]"
+	if array {
+		closing = "]]"
+	}
+	if !strings.HasPrefix(string(p.data[p.pos:]), closing) {
+		return p.errorf("missing %s after [%s", closing, strings.Join(path, "."))
+	}
+	p.pos += len(closing)
+
+	// Walk to the parent, creating tables that were only implied so far.
+	parent := p.root
+	for _, name := range path[:len(path)-1] {
+		if tables := parent.Arrays[name]; len(tables) > 0 {
+			parent = tables[len(tables)-1]
+			continue
+		}
+		sub, ok := parent.Tables[name]
+		if !ok {
+			if parent.has(name) {
+				return p.errorf("%s is not a table", name)
+			}
+			sub = newTable(line)
+			parent.Tables[name] = sub
+			parent.keys = append(parent.keys, name)
+		}
+		parent = sub
+	}
+
+	name := path[len(path)-1]
+	if array {
+		if _, ok := parent.Arrays[name]; !ok && parent.has(name) {
+			return p.errorf("%s is already defined on line %d", name, parent.line(name))
+		}
+		if _, ok := parent.Arrays[name]; !ok {
+			parent.keys = append(parent.keys, name)
+		}
+		p.current = newTable(line)
+		p.current.defined = true
+		parent.Arrays[name] = append(parent.Arrays[name], p.current)
+		return nil
+	}
+
+	sub, ok := parent.Tables[name]
+	switch {
+	case ok && sub.defined:
+		return p.errorf("table %s is already defined on line %d", strings.Join(path, "."), sub.Line)
+	case ok:
+		sub.Line = line
+	case parent.has(name):
+		return p.errorf("%s is already defined on line %d", name, parent.line(name))
+	default:
+		sub = newTable(line)
+		parent.Tables[name] = sub
+		parent.keys = append(parent.keys, name)
+	}
+	sub.defined = true
+	p.current = sub
+	return nil
+}
+
+// Parses a key = value line into the current table.
+func (p *parser) keyValue() error {
+	name, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipSpace()
+	if p.pos < len(p.data) && p.data[p.pos] == '.' {
+		return p.errorf("dotted keys are not supported, use a [table]")
+	}
+	if p.pos >= len(p.data) || p.data[p.pos] != '=' {
+		return p.errorf("missing = after %s", name)
+	}
+	p.pos++
+	p.skipSpace()
+
+	if p.current.has(name) {
+		return p.errorf("%s is already defined on line %d", name, p.current.line(name))
+	}
+	value, err := p.value()
+	if err != nil {
+		return err
+	}
+	p.current.Values[name] = value
+	p.current.keys = append(p.current.keys, name)
+	return nil
+}
+
+// Parses a value.
+func (p *parser) value() (*Value, error) {
+	if p.pos >= len(p.data) || p.data[p.pos] == '\n' || p.data[p.pos] == '#' {
+		return nil, p.errorf("missing value")
+	}
+	line := p.line
+
+	switch c := p.data[p.pos]; {
+	case c == '"' || c == '\'':
+		s, err := p.string(c)
+		return &Value{Line: line, Data: s}, err
+
+	case c == '[':
+		return p.array()
+
+	case c == 't' || c == 'f':
+		for _, word := range []string{"true", "false"} {
+			if strings.HasPrefix(string(p.data[p.pos:]), word) {
+				p.pos += len(word)
+				return &Value{Line: line, Data: word == "true"}, nil
+			}
+		}
+
+	case c == '+' || c == '-' || c >= '0' && c <= '9':
+		start := p.pos
+		for p.pos < len(p.data) && strings.IndexByte("+-_0123456789", p.data[p.pos]) >= 0 {
+			p.pos++
+		}
+		text := string(p.data[start:p.pos])
+		n, err := strconv.ParseInt(strings.ReplaceAll(text, "_", ""), 10, 64)
+		if err != nil || (p.pos < len(p.data) && p.data[p.pos] == '.') {
+			return nil, p.errorf("invalid number %s", text)
+		}
+		return &Value{Line: line, Data: n}, nil
+	}
+	return nil, p.errorf("invalid value")
+}
+
+// Parses a "basic" string with escapes or a 'literal' string.
+func (p *parser) string(quote byte) (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.data) {
+		c := p.data[p.pos]
+		switch {
+		case c == quote:
+			p.pos++
+			return b.String(), nil
+		case c == '\n':
+			return "", p.errorf("unterminated string")
+		case c == '\\' && quote == '"':
+			p.pos++
+			if p.pos >= len(p.data) {
+				return "", p.errorf("unterminated string")
+			}
+			switch e := p.data[p.pos]; e {
+			case '"', '\\':
+				b.WriteByte(e)
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			default:
+				return "", p.errorf("invalid escape \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+		p.pos++
+	}
+	return "", p.errorf("unterminated string")
+}
+
+// Parses an array, commas between the elements and a trailing comma are allowed.
+func (p *parser) array() (*Value, error) {
+	line := p.line
+	p.pos++
+	var values []*Value
+	for {
+		p.skipBlank()
+		if p.pos >= len(p.data) {
+			return nil, &Error{Line: line, Message: "unterminated array"}
+		}
+		if p.data[p.pos] == ']' {
+			p.pos++
+			return &Value{Line: line, Data: values}, nil
+		}
+
+		value, err := p.value()
+		if err != nil {
+			p.skipArray()
+			return nil, err
+		}
+		values = append(values, value)
+
+		p.skipBlank()
+		if p.pos < len(p.data) && p.data[p.pos] == ',' {
+			p.pos++
+		} else if p.pos < len(p.data) && p.data[p.pos] != ']' {
+			err := p.errorf("missing , between array elements")
+			p.skipArray()
+			return nil, err
+		}
+	}
+}
+
+// Skips past the closing ] of an array after an error inside it, so parsing
+// resumes after the array instead of in the middle of it.
+func (p *parser) skipArray() {
+	depth := 0
+	for p.pos < len(p.data) {
+		switch c := p.data[p.pos]; c {
+		case '\n':
+			p.line++
+		case '#':
+			for p.pos < len(p.data) && p.data[p.pos] != '\n' {
+				p.pos++
+			}
+			continue
+		case '"', '\'':
+			// Brackets inside strings don't count, strings end with the line.
+			for p.pos++; p.pos < len(p.data) && p.data[p.pos] != '\n'; p.pos++ {
+				if p.data[p.pos] == c {
+					p.pos++
+					break
+				}
+				if c == '"' && p.data[p.pos] == '\\' && p.pos+1 < len(p.data) && p.data[p.pos+1] != '\n' {
+					p.pos++
+				}
+			}
+			continue
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				p.pos++
+				return
+			}
+			depth--
+		}
+		p.pos++
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// Fails if the pid file belongs to a pisa that is still running.
+//
+// Checked before binding anything, so a second pisa started by mistake leaves
+// the running one and its pid file alone.
+func checkPidFile(path string) error {
+	pid, err := readPidFile(path)
+	if err == nil && pisaRunning(pid) {
+		return fmt.Errorf("pisa is already running with pid %d, see %s", pid, path)
+	}
+	return nil
+}
+
+// Writes the process id so pisa reload can find the running process.
+//
+// Only called once the server or relay agent is listening.
+func writePidFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// Returns the process id from a pid file.
+func readPidFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid file %s", path)
+	}
+	return pid, nil
+}
+
+// Reports whether a process is a running pisa.
+//
+// Pids are reused, so the process has to run the same executable as this one.
+// Processes of other users hide it, their name has to match instead.
+func pisaRunning(pid int) bool {
+	self, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err == nil {
+		// The executable may have been replaced since it was started.
+		return strings.TrimSuffix(exe, " (deleted)") == self
+	}
+	if !os.IsPermission(err) {
+		return false
+	}
+	comm, err := os.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	return err == nil && strings.TrimSpace(string(comm)) == truncateComm(filepath.Base(self))
+}
+
+// Returns a process name the way the kernel keeps it, at most 15 bytes.
+func truncateComm(name string) string {
+	if len(name) > 15 {
+		return name[:15]
+	}
+	return name
+}
+
+// Asks the running pisa to reload its configuration, returns its process id.
+func sendReload(pidFile string) (int, error) {
+	pid, err := readPidFile(pidFile)
+	if os.IsNotExist(err) {
+		return 0, fmt.Errorf("pisa doesn't seem to be running: %w", err)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// A stale pid may belong to another process by now, SIGHUP would terminate it.
+	if !pisaRunning(pid) {
+		return 0, fmt.Errorf("pisa doesn't seem to be running: pid %d from %s is gone or isn't pisa", pid, pidFile)
+	}
+	return pid, syscall.Kill(pid, syscall.SIGHUP)
+}
+
+// Starts catching SIGHUP, SIGINT and SIGTERM.
+//
+// Called before the pid file is written, a pisa reload right after would
+// otherwise terminate the process.
+func notifySignals() chan os.Signal {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM)
+	return signals
+}
+
+// Calls reload on every SIGHUP, removes the pid file and exits on SIGINT or SIGTERM.
+//
+// Runs for the lifetime of the process.
+func handleSignals(signals chan os.Signal, pidFile string, reload func()) {
+	for sig := range signals {
+		if sig == syscall.SIGHUP {
+			log.Println("Received SIGHUP, reloading the configuration")
+			reload()
+			continue
+		}
+
+		log.Println("Received", sig.String()+", stopping")
+		os.Remove(pidFile)
+		os.Exit(0)
+	}
+}
@@ -0,0 +1,157 @@
+package dhcp
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"time"
+)
+
+// Allocation strategies selectable in the configuration.
+const (
+	// Lowest free address of the pool.
+	Sequential = "sequential"
+	// Free address at a random position of the pool.
+	Random = "random"
+	// Free address derived from a hash of the client, so returning clients get the same one.
+	Sticky = "sticky"
+	// Free address that was released the longest time ago.
+	LeastRecentlyUsed = "lru"
+)
+
+// Picks addresses for clients out of a pool.
+type Allocator interface {
+	// Returns a free address for the client identified by id.
+	//
+	// free reports whether an address can be handed out.
+	Allocate(id string, free func(address uint32) bool) (uint32, bool)
+
+	// Hands back an address that is no longer leased.
+	Release(address uint32)
+}
+
+// Creates an allocator for the range first-last.
+func NewAllocator(strategy string, first uint32, last uint32) (Allocator, error) {
+	if first > last {
+		return nil, fmt.Errorf("invalid range: first address is above the last one")
+	}
+
+	switch strategy {
+	case Sequential, "":
+		return &sequentialAllocator{first: first, last: last}, nil
+	case Random:
+		return &probingAllocator{first: first, last: last, start: func(string) uint32 {
+			return rand.Uint32N(last - first + 1)
+		}}, nil
+	case Sticky:
+		return &probingAllocator{first: first, last: last, start: func(id string) uint32 {
+			h := fnv.New32a()
+			h.Write([]byte(id))
+			return h.Sum32() % (last - first + 1)
+		}}, nil
+	case LeastRecentlyUsed:
+		return &lruAllocator{first: first, last: last, released: make(map[uint32]time.Time)}, nil
+	}
+	return nil, fmt.Errorf("unknown allocator: %s", strategy)
+}
+
+// Hands out the lowest free address.
+type sequentialAllocator struct {
+	first uint32
+	last  uint32
+}
+
+func (a *sequentialAllocator) Allocate(id string, free func(uint32) bool) (uint32, bool) {
+	for addr := uint64(a.first); addr <= uint64(a.last); addr++ {
+		if free(uint32(addr)) {
+			return uint32(addr), true
+		}
+	}
+	return 0, false
+}
+
+func (a *sequentialAllocator) Release(address uint32) {}
+
+// Starts at an offset into the range and walks forward until it finds a free address.
+type probingAllocator struct {
+	first uint32
+	last  uint32
+	start func(id string) uint32
+}
+
+func (a *probingAllocator) Allocate(id string, free func(uint32) bool) (uint32, bool) {
+	size := uint64(a.last-a.first) + 1
+	offset := uint64(a.start(id))
+	for i := uint64(0); i < size; i++ {
+		addr := a.first + uint32((offset+i)%size)
+		if free(addr) {
+			return addr, true
+		}
+	}
+	return 0, false
+}
+
+func (a *probingAllocator) Release(address uint32) {}
+
+// Prefers addresses that were never handed out, then the ones released the longest time ago.
+//
+// The release history only lives in memory, it is carried over on reload but starts
+// empty after a restart.
+type lruAllocator struct {
+	first    uint32
+	last     uint32
+	released map[uint32]time.Time
+}
+
+func (a *lruAllocator) Allocate(id string, free func(uint32) bool) (uint32, bool) {
+	var best uint32
+	var bestTime time.Time
+	found := false
+	for addr := uint64(a.first); addr <= uint64(a.last); addr++ {
+		if !free(uint32(addr)) {
+			continue
+		}
+		released, used := a.released[uint32(addr)]
+		if !used {
+			// Never used, can't get any better.
+			return uint32(addr), true
+		}
+		if !found || released.Before(bestTime) {
+			best, bestTime, found = uint32(addr), released, true
+		}
+	}
+	if found {
+		delete(a.released, best)
+	}
+	return best, found
+}
+
+func (a *lruAllocator) Release(address uint32) {
+	a.released[address] = time.Now()
+}
+
+// Copies the release history of the lru pools of an old configuration into the
+// lru pools of a new one, for the addresses they share.
+func carryOverHistory(old []*Subnet, subnets []*Subnet) {
+	for _, subnet := range subnets {
+		for _, pool := range subnet.Pools {
+			lru, ok := pool.Allocator.(*lruAllocator)
+			if !ok {
+				continue
+			}
+			for _, oldSubnet := range old {
+				for _, oldPool := range oldSubnet.Pools {
+					previous, ok := oldPool.Allocator.(*lruAllocator)
+					if !ok {
+						continue
+					}
+					for address, released := range previous.released {
+						if address >= lru.first && address <= lru.last {
+							lru.released[address] = released
+						}
+					}
+				}
+			}
+		}
+	}
+}
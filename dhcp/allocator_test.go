@@ -0,0 +1,127 @@
+package dhcp
+
+import (
+	"testing"
+	"time"
+)
+
+// Returns a free function over a set of addresses in use.
+func freeExcept(used ...uint32) func(uint32) bool {
+	return func(address uint32) bool {
+		for _, u := range used {
+			if u == address {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestAllocate(t *testing.T) {
+	tests := []struct {
+		strategy string
+		used     []uint32
+		want     uint32
+	}{
+		{Sequential, nil, 10},
+		{Sequential, []uint32{10, 11, 13}, 12},
+		{LeastRecentlyUsed, nil, 10},
+		{LeastRecentlyUsed, []uint32{10}, 11},
+	}
+	for _, tt := range tests {
+		a, err := NewAllocator(tt.strategy, 10, 14)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := a.Allocate("client", freeExcept(tt.used...)); !ok || got != tt.want {
+			t.Errorf("%s with %v in use = %d, %v, want %d", tt.strategy, tt.used, got, ok, tt.want)
+		}
+	}
+}
+
+func TestAllocateExhausted(t *testing.T) {
+	for _, strategy := range []string{Sequential, Random, Sticky, LeastRecentlyUsed} {
+		a, err := NewAllocator(strategy, 10, 12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address, ok := a.Allocate("client", freeExcept(10, 11, 12)); ok {
+			t.Errorf("%s handed out %d from a full pool", strategy, address)
+		}
+		// The only free address is found wherever the search starts.
+		for _, id := range []string{"a", "b", "c", "d"} {
+			if address, ok := a.Allocate(id, freeExcept(10, 12)); !ok || address != 11 {
+				t.Errorf("%s for %s = %d, %v, want 11", strategy, id, address, ok)
+			}
+		}
+	}
+}
+
+func TestAllocateRandomStaysInRange(t *testing.T) {
+	a, _ := NewAllocator(Random, 100, 103)
+	for range 100 {
+		if address, ok := a.Allocate("client", freeExcept()); !ok || address < 100 || address > 103 {
+			t.Fatalf("random = %d, %v", address, ok)
+		}
+	}
+}
+
+func TestAllocateSticky(t *testing.T) {
+	a, _ := NewAllocator(Sticky, 1, 1000)
+	first, _ := a.Allocate("id:0100112233", freeExcept())
+	for range 10 {
+		if address, _ := a.Allocate("id:0100112233", freeExcept()); address != first {
+			t.Fatalf("sticky gave %d, then %d to the same client", first, address)
+		}
+	}
+	// Taken, the next address after it is used.
+	if address, _ := a.Allocate("id:0100112233", freeExcept(first)); address != first%1000+1 {
+		t.Errorf("sticky with %d taken = %d", first, address)
+	}
+}
+
+func TestAllocateLRU(t *testing.T) {
+	a, _ := NewAllocator(LeastRecentlyUsed, 10, 12)
+	lru := a.(*lruAllocator)
+	now := time.Now()
+	lru.released[10] = now.Add(-time.Minute)
+	lru.released[11] = now.Add(-time.Hour)
+
+	// Never used beats released.
+	if address, _ := a.Allocate("client", freeExcept()); address != 12 {
+		t.Errorf("lru = %d, want the never used 12", address)
+	}
+	// Released the longest time ago next.
+	if address, _ := a.Allocate("client", freeExcept(12)); address != 11 {
+		t.Errorf("lru = %d, want 11", address)
+	}
+	a.Release(11)
+	if address, _ := a.Allocate("client", freeExcept(12)); address != 10 {
+		t.Errorf("lru after releasing 11 = %d, want 10", address)
+	}
+}
+
+func TestNewAllocatorErrors(t *testing.T) {
+	if _, err := NewAllocator("fifo", 1, 2); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+	if _, err := NewAllocator(Sequential, 2, 1); err == nil {
+		t.Error("reversed range accepted")
+	}
+}
+
+func TestCarryOverHistory(t *testing.T) {
+	oldPool, _ := NewPool(10, 20, LeastRecentlyUsed, "")
+	released := time.Now().Add(-time.Hour)
+	oldPool.Allocator.(*lruAllocator).released[15] = released
+	oldPool.Allocator.(*lruAllocator).released[20] = released
+
+	newPool, _ := NewPool(10, 17, LeastRecentlyUsed, "")
+	other, _ := NewPool(30, 40, Sequential, "")
+	carryOverHistory([]*Subnet{{Pools: []*Pool{oldPool}}}, []*Subnet{{Pools: []*Pool{newPool, other}}})
+
+	history := newPool.Allocator.(*lruAllocator).released
+	if len(history) != 1 || !history[15].Equal(released) {
+		t.Errorf("history after reload = %v, want only 15", history)
+	}
+}
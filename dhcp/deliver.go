@@ -0,0 +1,99 @@
+package dhcp
+
+import (
+	"net"
+	"pisa/addresses"
+	"pisa/ethernet"
+	"pisa/packet"
+	"pisa/udp"
+	"pisa/util"
+)
+
+// BROADCAST bit of the flags field.
+const broadcastFlag uint16 = 0x8000
+
+var (
+	broadcastAddress = []byte{255, 255, 255, 255}
+	broadcastMAC     = []byte{255, 255, 255, 255, 255, 255}
+)
+
+// Sends a reply to the client that sent a request (RFC 2131 4.1).
+//
+// Relay agent information of the request is echoed in the reply and the options
+// are picked from what the client asked for.
+//
+// - relayed requests (giaddr set) are answered to the relay on port 67.
+// - clients with an address (ciaddr set) get the reply unicast to it.
+// - clients that set the BROADCAST bit or have no Ethernet address and every DHCPNAK get a broadcast.
+// - anyone else gets the reply unicast to yiaddr and its hardware address.
+func (s *DHCPServer) deliver(request *packet.Packet, reply *packet.Packet) error {
+	echoRelayInfo(request, reply)
+	selectOptions(request, reply)
+	nak := !request.Relayed() && isNak(reply)
+
+	switch {
+	case request.Relayed():
+		// The relay broadcasts NAKs on the client's network.
+		if isNak(reply) {
+			reply.Flags |= broadcastFlag
+		}
+		return s.sendUDP(reply, request.GatewayAddress, 67)
+
+	case request.ClientAddress != 0 && !nak:
+		return s.sendUDP(reply, request.ClientAddress, 68)
+
+	case request.Flags&broadcastFlag != 0 || nak || !hasEthernetAddress(request):
+		return s.sendFrame(reply, broadcastAddress, broadcastMAC)
+	}
+
+	return s.sendFrame(reply, util.Uint32Bytes(reply.YourAddress), request.ClientMAC)
+}
+
+// Reports whether the client hardware address of a packet can be used as an Ethernet destination.
+func hasEthernetAddress(p *packet.Packet) bool {
+	return p.HardwareAddressType == 1 && len(p.ClientMAC) == 6
+}
+
+// Reports whether a reply is a DHCPNAK.
+func isNak(reply *packet.Packet) bool {
+	messageType, _ := reply.Options.Uint8(packet.OptionMessageType)
+	return messageType == packet.DHCPNak
+}
+
+// Sends a reply through the regular network stack.
+func (s *DHCPServer) sendUDP(reply *packet.Packet, address uint32, port int) error {
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	_, err = s.SrvConn.WriteToUDP(data, &net.UDPAddr{
+		IP:   net.IP(util.Uint32Bytes(address)),
+		Port: port,
+	})
+	return err
+}
+
+// Sends a reply as a raw Ethernet frame, for clients that don't have an address yet.
+//
+// Such clients are only served on the configured interface, the frame goes out of it.
+func (s *DHCPServer) sendFrame(reply *packet.Packet, destination []byte, mac []byte) error {
+	return sendFrameOn(s.device, s.LocalAddress, reply, destination, mac)
+}
+
+// Sends a reply to a client as a raw Ethernet frame on a device.
+func sendFrameOn(device *net.Interface, source []byte, reply *packet.Packet, destination []byte, mac []byte) error {
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	// Source Destination address pair
+	address := addresses.Addresses{
+		Source:      source,
+		Destination: destination,
+	}
+	return ethernet.SendEthernet(data, &address, &udp.HeaderUDP{
+		SrcPort:  67,
+		DestPort: 68,
+	}, *device, mac)
+}
@@ -1,94 +1,102 @@
 package dhcp
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/binary"
 	"fmt"
 	"log"
 	"net"
-	"pisa/addresses"
-	"pisa/ethernet"
+	"pisa/lease"
 	"pisa/packet"
-	"pisa/udp"
 	"pisa/util"
-	"strings"
+	"sync"
 )
 
 // Struct representing options given to the DHCP server from the configuration file.
+//
+// Interface, LeaseFile, Grace, Probation, Authoritative and PidFile are settings of the server,
+// Mode, Upstream, RelayAgentInfo and RemoteID of the relay agent. Options of a network are in
+// SubnetOptions.
 type DHCPOptions struct {
-	Router     []string
-	SubnetMask string
-	DNS        []string
-	TimeServer []string
-	Interface  string
-	Lease      uint
+	Interface string
+	// Path of the lease database.
+	LeaseFile string
+	// Seconds an expired lease is held for its client before the address is reclaimed.
+	Grace uint
+	// Seconds a declined address is kept out of the pool.
+	Probation uint
+	// Whether the server is the only authority for the network and NAKs requests it knows nothing about.
+	Authoritative bool
+
+	// "server" to hand out leases, "relay" to forward clients to other servers.
+	Mode string
+	// Servers the relay agent forwards to.
+	Upstream []string
+	// Whether the relay agent adds option 82 to forwarded requests.
+	RelayAgentInfo bool
+	// Remote-ID the relay agent puts into option 82, the Circuit-ID is the client interface.
+	RemoteID string
+
+	// File the process id is written to, pisa reload signals the process in it.
+	PidFile string
 }
 
+// Modes pisa can run in.
+const (
+	ModeServer = "server"
+	ModeRelay  = "relay"
+)
+
+// Lease database used when none is configured.
+const DefaultLeaseFile = "pisa.leases"
+
+// Probation of declined addresses used when none is configured, in seconds.
+const DefaultProbation = 3600
+
 // Struct representing the DHCP server.
 type DHCPServer struct {
+	// Guards the server state, leases are expired from another goroutine.
+	mu sync.Mutex
+
 	SrvConn *net.UDPConn
-	Reader  *bufio.Reader
 	Options *DHCPOptions
 	Buffer  []byte
+	// Buffer for control messages (IP_PKTINFO).
+	oob []byte
 
-	// Clients mapped by MAC to Address -> will soon be actually used.
-	Clients map[string]uint32
+	// Leases handed out to clients, persisted across restarts.
+	Leases *lease.Store
 
-	// First address assignable
-	RangeFirst uint32
-	// Last address assignable
-	RangeLast uint32
+	// Networks served, each with its own ranges and options.
+	Subnets []*Subnet
 
-	// Current highest address -> will be probably removed.
-	HighestAddr uint32
+	// Hosts with fixed addresses.
+	Reservations []*Reservation
 
+	// Interface the server serves clients on, replies to clients on the link go out of it.
+	device *net.Interface
 	// Local address represented as []byte
 	LocalAddress []byte
-
-	// Released addresses in []uint32
-	ReleasedAddresses []uint32
-	// Options actually set in the configuration.
-	availableOptions []string
-
-	// Parsed options in []byte.
-	//
-	// This lets the server need to calculate (most) of the options only once.
-	//
-	// Stuff like addresses is dynamic ofc.
-	parsedOptions []byte
-}
-
-// Reads from the connection (Port 67).
-func (s *DHCPServer) Read() ([]byte, error) {
-	length, err := s.Reader.Read(s.Buffer)
-	return s.Buffer[:length], err
-}
-
-// Generates an IP address.
-func (s *DHCPServer) generateAddress(mac string) []byte {
-	address := make([]byte, 4)
-	binary.BigEndian.PutUint32(address, s.HighestAddr)
-	s.HighestAddr += 1
-	return address
+	// Network of the interface, for subnets that leave out their network.
+	localNetwork *net.IPNet
 }
 
 // Start server.
 //
 // Requires a map of options.
 //
-// first used address in uint32 form.
-//
-// last used address in uint32 form.
+// subnets served, a subnet without a network stands for the network of the interface.
 //
-// available options as an slice of strings.
-func StartServer(opt *DHCPOptions, rangeFirst uint32, rangeLast uint32, availableOptions []string) *DHCPServer {
+// reservations of fixed addresses.
+func StartServer(opt *DHCPOptions, subnets []*Subnet, reservations []*Reservation) *DHCPServer {
 	// Get local address
 	device, err := net.InterfaceByName(opt.Interface)
 	util.OnError(err)
-	addrs, err := device.Addrs()
-	util.OnError(err)
-	address := strings.Split(addrs[0].String(), "/")
+	networks := interfaceNetworks(device)
+	if len(networks) == 0 {
+		util.OnError(fmt.Errorf("no IPv4 address on interface %s", opt.Interface))
+	}
+	local := networks[0]
 
 	// Connection
 	s, err := net.ListenUDP("udp", &net.UDPAddr{
@@ -96,224 +104,253 @@ func StartServer(opt *DHCPOptions, rangeFirst uint32, rangeLast uint32, availabl
 		IP:   net.ParseIP("0.0.0.0"),
 	})
 
+	util.OnError(err)
+	util.OnError(enablePacketInfo(s))
+
+	// Lease database
+	if opt.LeaseFile == "" {
+		opt.LeaseFile = DefaultLeaseFile
+	}
+	leases, err := lease.Open(opt.LeaseFile)
 	util.OnError(err)
 
-	reader := bufio.NewReader(s)
+	if opt.Probation == 0 {
+		opt.Probation = DefaultProbation
+	}
+
+	// Subnets
+	util.OnError(prepareSubnets(subnets, local))
+	for _, subnet := range subnets {
+		log.Println("Serving subnet", subnet.String(), "with", len(subnet.Pools), "pools")
+	}
 
-	// Buffer for data.
-	buffer := make([]byte, 512)
+	// Buffer for data, large enough for any datagram on an Ethernet link.
+	buffer := make([]byte, 1500)
 
 	Server := &DHCPServer{
 		// Related to the connection
 		SrvConn: s,
-		Reader:  reader,
 		Buffer:  buffer,
+		oob:     make([]byte, 128),
 
 		// Related to configuration
-		Options:           opt,
-		RangeFirst:        rangeFirst,
-		RangeLast:         rangeLast,
-		HighestAddr:       rangeFirst,
-		availableOptions:  availableOptions,
-		Clients:           make(map[string]uint32),
-		LocalAddress:      addresses.ParseIP(address[0]),
-		ReleasedAddresses: make([]uint32),
+		Options:      opt,
+		Subnets:      subnets,
+		Reservations: reservations,
+		Leases:       leases,
+		device:       device,
+		LocalAddress: []byte(local.IP),
+		localNetwork: local,
 	}
 
-	// Sets a ready byte array of options.
-	Server.createOptions(opt)
+	log.Println("Restored", len(leases.All()), "leases from", opt.LeaseFile)
+
+	// Expire leases in the background.
+	go Server.reclaimLeases()
 
 	// Logging.
-	log.Println("Started server on address:", address[0], "!")
+	log.Println("Started server on address:", local.IP.String(), "!")
 
 	return Server
 }
 
-// Create a []byte of options ready to be appended to a packet.
-//
-// DNS, Time Server, Router options can be multiple addresses.
-//
-// I will include that later.
-func (s *DHCPServer) createOptions(opt *DHCPOptions) {
-	optBuffer := new(bytes.Buffer)
-	optBuffer.Write(util.MagicCookie)
-	for i := 0; i <= len(s.availableOptions)-1; i++ {
-		fmt.Println(s.availableOptions[i])
-		switch s.availableOptions[i] {
-
+// Encodes the named options from opt followed by the extra ones.
+func BuildOptions(opt *SubnetOptions, names []string) packet.Options {
+	var options packet.Options
+	for _, name := range names {
+		switch name {
 		case "router":
-			optBuffer.Write([]byte{3, byte(len(opt.Router) * 4)})
-			for i := 0; i <= len(opt.Router)-1; i++ {
-				optBuffer.Write(util.AddressIntoBytearray(opt.Router[i]))
-			}
+			options.SetIPs(packet.OptionRouter, addressList(opt.Router))
 
 		case "subnetmask":
-			optBuffer.Write([]byte{1, 4})
-			optBuffer.Write(util.AddressIntoBytearray(opt.SubnetMask))
+			options.SetIP(packet.OptionSubnetMask, util.AddressIntoBytearray(opt.SubnetMask))
+
+		case "routes":
+			// Both the standard option and the one of older Windows clients.
+			routes, err := EncodeRoutes(opt.Routes)
+			if err != nil {
+				util.NonFatalError(err)
+				continue
+			}
+			options.Set(OptionClasslessRoutes, routes)
+			options.Set(OptionMSClasslessRoutes, routes)
 
 		case "dns":
-			optBuffer.Write([]byte{6, byte(len(opt.DNS) * 4)})
-			for i := 0; i <= len(opt.DNS)-1; i++ {
-				optBuffer.Write(util.AddressIntoBytearray(opt.DNS[i]))
-			}
+			options.SetIPs(packet.OptionDNS, addressList(opt.DNS))
 
 		case "timesvr":
-			optBuffer.Write([]byte{4, byte(len(opt.TimeServer) * 4)})
-			for i := 0; i <= len(opt.TimeServer)-1; i++ {
-				optBuffer.Write(util.AddressIntoBytearray(opt.TimeServer[i]))
-			}
+			options.SetIPs(packet.OptionTimeServer, addressList(opt.TimeServer))
+
 		case "lease":
 			// Option 51: Lease time
-			optBuffer.Write([]byte{51, 4})
-			valueInt := uint32(opt.Lease)
-
-			leaseBytes := make([]byte, 4)
-			binary.BigEndian.PutUint32(leaseBytes, uint32(valueInt))
-			optBuffer.Write(leaseBytes)
-
+			lease := uint32(opt.Lease)
+			options.SetUint32(packet.OptionLeaseTime, lease)
 			// T1 time
-			optBuffer.Write([]byte{58, 4})
-			binary.BigEndian.PutUint32(leaseBytes, uint32(valueInt/2))
-			optBuffer.Write(leaseBytes)
-
+			options.SetUint32(packet.OptionRenewalTime, lease/2)
 			// T2 time
-			optBuffer.Write([]byte{59, 4})
-			binary.BigEndian.PutUint32(leaseBytes, uint32(valueInt*825/1000))
-			optBuffer.Write(leaseBytes)
+			options.SetUint32(packet.OptionRebindingTime, lease*825/1000)
 		}
 	}
+	for _, extra := range opt.Extra {
+		options.Set(extra.Code, extra.Data)
+	}
+	return options
+}
 
-	// Padding with 0s
-	//optBuffer.Write(make([]byte, 191-len(optBuffer.Bytes())))
-	s.parsedOptions = optBuffer.Bytes()
+// Converts address strings into byte arrays.
+func addressList(addrs []string) [][]byte {
+	list := make([][]byte, len(addrs))
+	for i, addr := range addrs {
+		list[i] = util.AddressIntoBytearray(addr)
+	}
+	return list
 }
 
-// Sends a DHCP Offer.
+// Creates a reply to a client's packet.
 //
-// Returns a error.
-func (s *DHCPServer) SendDHCPOffer(p *packet.Packet) error {
-	offer := new(bytes.Buffer)
-
-	// Generate a IP address from the ranges.
-	if s.RangeFirst+1 > s.RangeLast {
-		return fmt.Errorf("Address range exhausted!")
+// The reply carries the message type and server identifier followed by
+// the options of the subnet, a DHCPNAK only carries the first two.
+func (s *DHCPServer) newReply(p *packet.Packet, subnet *Subnet, messageType uint8, yourAddress uint32) *packet.Packet {
+	options := packet.Options{
+		{Code: packet.OptionMessageType, Data: []byte{messageType}},
+		{Code: packet.OptionServerIdentifier, Data: s.LocalAddress},
 	}
-	var ip []byte
-	if s.Clients[p.StringMAC] == 0 {
-		if len(s.ReleasedAddresses) > 0 {
-			ip = s.ReleasedAddresses[0]
-			s.ReleasedAddresses = s.ReleasedAddresses[1:]
-		} else {
-			ip = s.generateAddress(p.StringMAC)
-			s.Clients[mac] = s.HighestAddr
-		}
-	} else {
-		ip = util.Uint32Bytes(s.Clients[p.StringMAC])
+	if messageType != packet.DHCPNak && subnet != nil {
+		options = append(options, subnet.parsedOptions.Copy()...)
+	}
+	var clientAddress uint32
+	if messageType == packet.DHCPAck {
+		clientAddress = p.ClientAddress
 	}
 
-	// opcode, htype, hlen, hops
-	offer.Write([]byte{
-		2, 1, 6, 0,
-	})
+	return &packet.Packet{
+		Opcode:                packet.BootReply,
+		HardwareAddressType:   p.HardwareAddressType,
+		HardwareAddressLength: p.HardwareAddressLength,
 
-	// Transaction ID
-	offer.Write(p.TransactionID)
-
-	// Fields
-	offer.Write([]byte{
-		0, 0, // SECONDS
-		0, 0, // FLAGS
-		0, 0, 0, 0, // CLIENT IP
-		ip[0], ip[1], ip[2], ip[3], // YOUR IP
-		s.LocalAddress[0], s.LocalAddress[1], s.LocalAddress[2], s.LocalAddress[3], // SERVER IP
-		0, 0, 0, 0, // GATEWAY IP
-	})
+		TransactionID: p.TransactionID,
+		Flags:         p.Flags,
 
-	// Client's MAC Address
-	offer.Write(p.ClientMAC)
+		// A DHCPACK echoes the client's address (RFC 2131 4.3.1 Table 3).
+		ClientAddress: clientAddress,
+		YourAddress:   yourAddress,
+		ServerAddress: binary.BigEndian.Uint32(s.LocalAddress),
+		// The relay finds the client's network by it.
+		GatewayAddress: p.GatewayAddress,
 
-	// server hostname not needed, will be added
-	offer.Write(make([]byte, 64))
-	// bootfile
-	offer.Write(make([]byte, 138))
+		ClientMAC: p.ClientMAC,
+		Options:   options,
+	}
+}
 
-	// Append options
-	offer.Write(s.parsedOptions)
-	// Option 53: DHCP Message type and Option 255 End
-	offer.Write([]byte{53, 1, 2, 255})
-	// Send frame directory to the specified interface
-	device, err := net.InterfaceByName(s.Options.Interface)
-	util.OnError(err)
+// Reports whether a packet is meant for this server.
+//
+// Clients name the server they talk to in option 54, packets without it
+// are accepted.
+func (s *DHCPServer) addressedToUs(p *packet.Packet) bool {
+	serverID, ok := p.Options.Get(packet.OptionServerIdentifier)
+	return !ok || bytes.Equal(serverID, s.LocalAddress)
+}
 
-	// Source Destination address pair
-	address := addresses.Addresses{
-		Source:      addresses.ParseIP("192.168.0.1"),
-		Destination: addresses.ParseIP("192.168.0.2"),
+// Reports whether the server answers a packet.
+//
+// Broadcasts come from clients on the link they arrive on, only the ones on the
+// configured interface are served. Relayed packets and unicasts from clients with
+// an address may arrive on any interface.
+func (s *DHCPServer) Serves(p *packet.Packet) bool {
+	if p.Relayed() || p.Interface == 0 || p.Interface == s.device.Index {
+		return true
 	}
-	err = ethernet.SendEthernet(offer.Bytes(), &address, &udp.HeaderUDP{
-		SrcPort:  67,
-		DestPort: 68,
-	}, *device, p.ClientMAC)
-
-	return err
+	return p.ClientAddress != 0 && p.Destination != binary.BigEndian.Uint32(broadcastAddress)
 }
 
-// Sends a DHCP Acknowledge.
+// Sends a DHCP Offer.
 //
 // Returns a error.
-func (s *DHCPServer) SendDHCPAck(packet *packet.Packet) error {
-	// opcode, hardware type, hardware addres length and hops
-	ack := bytes.NewBuffer([]byte{
-		2, 1, 6, 0,
-	})
-
-	// transaction id
-	ack.Write(packet.TransactionID)
+func (s *DHCPServer) SendDHCPOffer(p *packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	assignedAddr := s.Clients[packet.StringMAC]
+	subnet := s.subnetFor(p)
+	if subnet == nil {
+		return fmt.Errorf("no subnet for DHCPDISCOVER from %s", p.StringMAC)
+	}
 
-	ack.Write([]byte{
-		0, 0,
-		0, 0,
-		0, 0, 0, 0,
-	})
-	binary.Write(ack, binary.BigEndian, assignedAddr)
-	ack.Write([]byte{
-		s.LocalAddress[0], s.LocalAddress[1], s.LocalAddress[2], s.LocalAddress[3], // SERVER IP
-		0, 0, 0, 0, // gateway ip
-	})
+	// Offer the reserved address, the client's current one, the one it asked for
+	// or allocate one from the pools.
+	var address uint32
+	reservation := s.reservation(p, subnet)
+	requested, hasRequested := p.Options.Uint32(packet.OptionRequestedAddress)
+	if reservation != nil {
+		address = reservation.Address
+		err := s.moveToReservation(p, subnet, reservation)
+		if err != nil {
+			return err
+		}
+	} else if l, ok := s.clientLease(p, subnet); ok {
+		address = l.Address
+	} else if hasRequested && s.validAddress(p, subnet, requested) && s.addressFree(requested) {
+		address = requested
+	} else {
+		address, ok = s.allocate(p, subnet)
+		if !ok {
+			return fmt.Errorf("Address range of %s exhausted!", subnet)
+		}
+	}
 
-	// Client MAC
-	ack.Write(packet.ClientMAC)
+	err := s.recordLease(p, address, lease.Offered)
+	if err != nil {
+		return err
+	}
 
-	// Server hostname
-	ack.Write(make([]byte, 64))
-	// Bootfile
-	ack.Write(make([]byte, 138))
-	// Option 53: DHCP Message type and Option 255 End
-	ack.Write(s.parsedOptions)
-	ack.Write([]byte{53, 1, 5, 255})
+	offer := s.newReply(p, subnet, packet.DHCPOffer, address)
+	reservation.apply(offer)
+	return s.deliver(p, offer)
+}
 
-	// Send frame directory to the specified interface
-	device, err := net.InterfaceByName(s.Options.Interface)
-	util.OnError(err)
+// Allocates a free address from the pools of a subnet the client is admitted to.
+//
+// Pools limited to the client's relay agent port are tried first.
+func (s *DHCPServer) allocate(p *packet.Packet, subnet *Subnet) (uint32, bool) {
+	for _, restricted := range []bool{true, false} {
+		for _, pool := range subnet.Pools {
+			if pool.restricted() != restricted || !pool.admits(p) {
+				continue
+			}
+			if address, ok := pool.Allocator.Allocate(pool.clientKey(p), s.addressFree); ok {
+				return address, true
+			}
+		}
+	}
+	return 0, false
+}
 
-	// Source Destination address pair
-	address := addresses.Addresses{
-		Source:      s.LocalAddress,
-		Destination: util.Uint32Bytes(assignedAddr),
+// Sends a DHCP Acknowledge for a lease.
+//
+// Returns a error.
+func (s *DHCPServer) sendAck(p *packet.Packet, subnet *Subnet, l lease.Lease) error {
+	err := s.recordLease(p, l.Address, lease.Bound)
+	if err != nil {
+		return err
 	}
-	// Write to the socket.
-	err = ethernet.SendEthernet(ack.Bytes(), &address, &udp.HeaderUDP{
-		SrcPort:  67,
-		DestPort: 68,
-	}, *device, packet.ClientMAC)
 
-	log.Println("DHCPACK to: ", packet.StringMAC)
+	ack := s.newReply(p, subnet, packet.DHCPAck, l.Address)
+	s.reservation(p, subnet).apply(ack)
+	err = s.deliver(p, ack)
+
+	log.Println("DHCPACK to: ", p.StringMAC)
 	return err
 }
 
-func (s *DHCPserver) Release(p *packet.Packet) {
-	s.Clients[p.StringMAC] = 0
-	s.ReleasedAddresses = append(s.ReleasedAddresses, binary.BigEndian.Uint32(p.YourAddress))
+// Sends a DHCP Negative Acknowledge with a message explaining why.
+//
+// Returns a error.
+func (s *DHCPServer) SendDHCPNak(p *packet.Packet, message string) error {
+	nak := s.newReply(p, nil, packet.DHCPNak, 0)
+	nak.ServerAddress = 0
+	nak.Options.SetString(packet.OptionMessage, message)
+	err := s.deliver(p, nak)
+
+	log.Println("DHCPNAK to:", p.StringMAC, "("+message+")")
+	return err
 }
@@ -0,0 +1,32 @@
+package dhcp
+
+import (
+	"net"
+	"pisa/packet"
+	"testing"
+)
+
+func TestServes(t *testing.T) {
+	s := &DHCPServer{device: &net.Interface{Index: 2}}
+	tests := []struct {
+		name        string
+		iface       int
+		ciaddr      uint32
+		giaddr      uint32
+		destination uint32
+		want        bool
+	}{
+		{"broadcast on the interface", 2, 0, 0, 0xffffffff, true},
+		{"without packet information", 0, 0, 0, 0xffffffff, true},
+		{"broadcast on another interface", 3, 0, 0, 0xffffffff, false},
+		{"rebinding on another interface", 3, 0x0a000005, 0, 0xffffffff, false},
+		{"renewal routed in", 3, 0x0a000005, 0, 0xc0a80001, true},
+		{"relayed", 3, 0, 0x0a000001, 0xc0a80001, true},
+	}
+	for _, tt := range tests {
+		p := &packet.Packet{Interface: tt.iface, ClientAddress: tt.ciaddr, GatewayAddress: tt.giaddr, Destination: tt.destination}
+		if got := s.Serves(p); got != tt.want {
+			t.Errorf("%s: serves = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package dhcp
+
+import (
+	"log"
+	"pisa/packet"
+	"pisa/util"
+)
+
+// Handles a DHCPINFORM from a host that configured its address by itself.
+//
+// The host gets the configured options in a DHCPACK unicast to its address,
+// without an address or lease time (RFC 2131 4.3.5).
+func (s *DHCPServer) HandleInform(p *packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.ClientAddress == 0 {
+		log.Println("Ignoring DHCPINFORM without a client address from", p.StringMAC)
+		return nil
+	}
+
+	subnet := s.subnetFor(p)
+	if subnet == nil {
+		subnet = s.subnetOf(p.ClientAddress)
+	}
+	if subnet == nil {
+		log.Println("No subnet for DHCPINFORM from", util.Uint32IntoAddress(p.ClientAddress))
+		return nil
+	}
+
+	ack := s.newReply(p, subnet, packet.DHCPAck, 0)
+	s.reservation(p, subnet).apply(ack)
+
+	// The host keeps its address, nothing about a lease applies.
+	ack.Options.Delete(packet.OptionLeaseTime)
+	ack.Options.Delete(packet.OptionRenewalTime)
+	ack.Options.Delete(packet.OptionRebindingTime)
+
+	err := s.deliver(p, ack)
+
+	log.Println("DHCPACK (inform) to:", util.Uint32IntoAddress(p.ClientAddress))
+	return err
+}
@@ -0,0 +1,87 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"log"
+	"pisa/lease"
+	"pisa/packet"
+	"pisa/util"
+	"time"
+)
+
+// How long an offered address is held for the client.
+const offerTimeout = time.Minute
+
+// How often leases are checked for expiry.
+const reclaimInterval = 10 * time.Second
+
+// Returns the client identifier (option 61) of a packet as a hex string.
+func clientID(p *packet.Packet) string {
+	id, _ := p.Options.Get(packet.OptionClientIdentifier)
+	return hex.EncodeToString(id)
+}
+
+// Returns the grace period as a duration.
+func (s *DHCPServer) graceDuration() time.Duration {
+	return time.Duration(s.Options.Grace) * time.Second
+}
+
+// Returns the current lease in a subnet of the client that sent a packet.
+func (s *DHCPServer) clientLease(p *packet.Packet, subnet *Subnet) (lease.Lease, bool) {
+	return s.Leases.Find(func(l *lease.Lease) bool {
+		return l.Active() && subnet.Contains(l.Address) && subnet.owns(l, p)
+	})
+}
+
+// Reports whether an address can be handed out to a client.
+func (s *DHCPServer) addressFree(address uint32) bool {
+	if address == binary.BigEndian.Uint32(s.LocalAddress) || s.reserved(address) {
+		return false
+	}
+	// Released addresses are free right away, declined ones stay out until their probation ends.
+	l, leased := s.Leases.Get(address)
+	return !leased || l.State == lease.Released
+}
+
+// Records a lease of an address for the client that sent a packet.
+func (s *DHCPServer) recordLease(p *packet.Packet, address uint32, state lease.State) error {
+	now := time.Now()
+	expiry := now.Add(time.Duration(DefaultLease) * time.Second)
+	if subnet := s.subnetOf(address); subnet != nil {
+		expiry = now.Add(subnet.leaseDuration())
+	}
+	if state == lease.Offered {
+		expiry = now.Add(offerTimeout)
+	}
+
+	return s.Leases.Put(lease.Lease{
+		MAC:      p.StringMAC,
+		ClientID: clientID(p),
+		Address:  address,
+		Start:    now,
+		Expiry:   expiry,
+		State:    state,
+	})
+}
+
+// Expires leases and hands reclaimed addresses back to the pool.
+//
+// Runs for the lifetime of the server.
+func (s *DHCPServer) reclaimLeases() {
+	ticker := time.NewTicker(reclaimInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		s.mu.Lock()
+		reclaimed, err := s.Leases.Expire(now, s.graceDuration())
+		for _, l := range reclaimed {
+			log.Println("Reclaimed", util.Uint32IntoAddress(l.Address), "from", l.MAC, "("+l.State.String()+")")
+			if pool := s.poolOf(l.Address); pool != nil {
+				pool.Allocator.Release(l.Address)
+			}
+		}
+		s.mu.Unlock()
+		util.NonFatalError(err)
+	}
+}
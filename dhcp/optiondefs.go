@@ -0,0 +1,239 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"pisa/util"
+	"strconv"
+	"strings"
+)
+
+// Types of option values.
+const (
+	TypeIP     = "ip"
+	TypeIPList = "ip-list"
+	TypeUint8  = "uint8"
+	TypeUint16 = "uint16"
+	TypeUint32 = "uint32"
+	TypeString = "string"
+	TypeBool   = "bool"
+	TypeHex    = "hex"
+	// Domain names encoded as in DNS (RFC 1035), for the domain search list.
+	TypeDomainList = "domain-list"
+	// Routes in the form "10.0.0.0/8 via 192.168.0.1", encoded as in RFC 3442.
+	TypeRoutes = "routes"
+)
+
+// How an option is named in the configuration and how its value is encoded.
+type OptionDefinition struct {
+	Name string
+	Code uint8
+	Type string
+}
+
+// Options with a name of their own.
+var StandardOptions = []OptionDefinition{
+	{"subnetmask", 1, TypeIP},
+	{"router", 3, TypeIPList},
+	{"timesvr", 4, TypeIPList},
+	{"dns", 6, TypeIPList},
+	{"log-servers", 7, TypeIPList},
+	{"hostname", 12, TypeString},
+	{"domain-name", 15, TypeString},
+	{"root-path", 17, TypeString},
+	{"ip-forwarding", 19, TypeBool},
+	{"default-ttl", 23, TypeUint8},
+	{"mtu", 26, TypeUint16},
+	{"broadcast-address", 28, TypeIP},
+	{"router-discovery", 31, TypeBool},
+	{"nis-domain", 40, TypeString},
+	{"nis-servers", 41, TypeIPList},
+	{"ntp-servers", 42, TypeIPList},
+	{"vendor-specific", 43, TypeHex},
+	{"netbios-name-servers", 44, TypeIPList},
+	{"netbios-node-type", 46, TypeUint8},
+	{"tftp-server", 66, TypeString},
+	{"bootfile", 67, TypeString},
+	{"smtp-servers", 69, TypeIPList},
+	{"domain-search", 119, TypeDomainList},
+	{"classless-routes", OptionClasslessRoutes, TypeRoutes},
+	{"ms-classless-routes", OptionMSClasslessRoutes, TypeRoutes},
+	{"wpad", 252, TypeString},
+}
+
+// Returns the standard option with a name.
+func LookupOption(name string) (OptionDefinition, bool) {
+	for _, def := range StandardOptions {
+		if def.Name == name {
+			return def, true
+		}
+	}
+	return OptionDefinition{}, false
+}
+
+// Checks a definition of a custom option.
+//
+// Options that are part of the protocol, set from other settings, only sent by
+// clients or added by relay agents (82) can't be defined.
+func (def *OptionDefinition) Validate() error {
+	switch def.Code {
+	case 0, 255:
+		return fmt.Errorf("option %d can't be configured", def.Code)
+	case 50, 51, 52, 53, 54, 55, 57, 58, 59, 61, 82:
+		return fmt.Errorf("option %d is managed by pisa", def.Code)
+	}
+	switch def.Type {
+	case TypeIP, TypeIPList, TypeUint8, TypeUint16, TypeUint32, TypeString, TypeBool, TypeHex, TypeDomainList, TypeRoutes:
+		return nil
+	}
+	return fmt.Errorf("unknown option type %q", def.Type)
+}
+
+// Encodes the value of an option, values are given as text.
+//
+// Types holding a single value take exactly one.
+func (def *OptionDefinition) Encode(values []string) ([]byte, error) {
+	if len(values) == 0 {
+		return nil, fmt.Errorf("%s: missing value", def.Name)
+	}
+	switch def.Type {
+	case TypeIPList, TypeDomainList, TypeRoutes:
+	default:
+		if len(values) > 1 {
+			return nil, fmt.Errorf("%s: takes a single value", def.Name)
+		}
+	}
+
+	var data []byte
+	for _, value := range values {
+		encoded, err := encodeValue(def.Type, value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", def.Name, err)
+		}
+		data = append(data, encoded...)
+	}
+	return data, nil
+}
+
+// Encodes a single value of a type.
+func encodeValue(typ string, value string) ([]byte, error) {
+	switch typ {
+	case TypeIP, TypeIPList:
+		if !util.CheckAddress(value) {
+			return nil, fmt.Errorf("invalid address %q", value)
+		}
+		return util.AddressIntoBytearray(value), nil
+
+	case TypeUint8, TypeUint16, TypeUint32:
+		bits, _ := strconv.Atoi(strings.TrimPrefix(typ, "uint"))
+		n, err := strconv.ParseUint(value, 10, bits)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q", typ, value)
+		}
+		return binary.BigEndian.AppendUint32(nil, uint32(n))[4-bits/8:], nil
+
+	case TypeString:
+		if value == "" {
+			return nil, fmt.Errorf("empty string")
+		}
+		return []byte(value), nil
+
+	case TypeBool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bool %q", value)
+		}
+		if b {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+
+	case TypeHex:
+		s, err := parseHex(value)
+		if err != nil {
+			return nil, err
+		}
+		return hex.DecodeString(s)
+
+	case TypeDomainList:
+		return encodeDomain(value)
+
+	case TypeRoutes:
+		return encodeRoute(value)
+	}
+	return nil, fmt.Errorf("unknown option type %q", typ)
+}
+
+// Encodes a domain name as a sequence of labels (RFC 1035 3.1).
+func encodeDomain(name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name == "" || len(name) > 253 {
+		return nil, fmt.Errorf("invalid domain %q", name)
+	}
+	var b []byte
+	for _, label := range strings.Split(name, ".") {
+		if label == "" || len(label) > 63 {
+			return nil, fmt.Errorf("invalid domain %q", name)
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0), nil
+}
+
+// Option codes of classless static routes, the standard one and the one older Windows clients ask for.
+const (
+	OptionClasslessRoutes   uint8 = 121
+	OptionMSClasslessRoutes uint8 = 249
+)
+
+// Encodes routes in the form "10.0.0.0/8 via 192.168.0.1" for the classless static route options.
+func EncodeRoutes(routes []string) ([]byte, error) {
+	var data []byte
+	for _, route := range routes {
+		encoded, err := encodeRoute(route)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, encoded...)
+	}
+	return data, nil
+}
+
+// Reports whether a default route (0.0.0.0/0) is among routes.
+//
+// Clients that get classless static routes ignore the router option (RFC 3442),
+// so the default route has to be repeated in them.
+func HasDefaultRoute(routes []string) bool {
+	for _, route := range routes {
+		if fields := strings.Fields(route); len(fields) > 0 && fields[0] == "0.0.0.0/0" {
+			return true
+		}
+	}
+	return false
+}
+
+// Encodes a route "10.0.0.0/8 via 192.168.0.1" as a destination descriptor
+// followed by the router (RFC 3442).
+//
+// The descriptor is the prefix length and only the significant octets of the destination.
+func encodeRoute(route string) ([]byte, error) {
+	fields := strings.Fields(route)
+	if len(fields) != 3 || fields[1] != "via" || !util.CheckAddress(fields[2]) {
+		return nil, fmt.Errorf("invalid route %q, must be network/prefix via router", route)
+	}
+	ip, network, err := net.ParseCIDR(fields[0])
+	if err != nil || ip.To4() == nil {
+		return nil, fmt.Errorf("invalid route destination %q", fields[0])
+	}
+	if !ip.Equal(network.IP) {
+		return nil, fmt.Errorf("route destination %s has bits set beyond its prefix", fields[0])
+	}
+
+	ones, _ := network.Mask.Size()
+	b := []byte{byte(ones)}
+	b = append(b, network.IP.To4()[:(ones+7)/8]...)
+	return append(b, util.AddressIntoBytearray(fields[2])...), nil
+}
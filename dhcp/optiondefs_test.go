@@ -0,0 +1,58 @@
+package dhcp
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncodeRoute(t *testing.T) {
+	tests := []struct {
+		route string
+		want  []byte
+		err   string
+	}{
+		{route: "0.0.0.0/0 via 10.0.0.1", want: []byte{0, 10, 0, 0, 1}},
+		{route: "10.0.0.0/8 via 192.168.0.1", want: []byte{8, 10, 192, 168, 0, 1}},
+		{route: "172.16.0.0/16 via 192.168.0.1", want: []byte{16, 172, 16, 192, 168, 0, 1}},
+		{route: "192.168.1.128/25 via 192.168.0.1", want: []byte{25, 192, 168, 1, 128, 192, 168, 0, 1}},
+		{route: "10.0.0.5/32 via 10.0.0.1", want: []byte{32, 10, 0, 0, 5, 10, 0, 0, 1}},
+		{route: "10.1.0.0/8 via 192.168.0.1", err: "route destination 10.1.0.0/8 has bits set beyond its prefix"},
+		{route: "192.168.1.129/25 via 192.168.0.1", err: "route destination 192.168.1.129/25 has bits set beyond its prefix"},
+		{route: "10.0.0.0/33 via 10.0.0.1", err: "invalid route destination"},
+		{route: "10.0.0.0/8 10.0.0.1", err: "must be network/prefix via router"},
+		{route: "10.0.0.0/8 via router", err: "must be network/prefix via router"},
+	}
+	for _, tt := range tests {
+		got, err := encodeRoute(tt.route)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s: error = %v, want %q", tt.route, err, tt.err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("%s = %v, %v, want %v", tt.route, got, err, tt.want)
+		}
+	}
+}
+
+func TestEncodeRoutes(t *testing.T) {
+	routes := []string{"0.0.0.0/0 via 10.0.0.1", "10.8.0.0/16 via 10.0.0.2"}
+	data, err := EncodeRoutes(routes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Destination descriptors and routers back to back, no separators.
+	want := []byte{0, 10, 0, 0, 1, 16, 10, 8, 10, 0, 0, 2}
+	if !bytes.Equal(data, want) {
+		t.Errorf("routes = %v, want %v", data, want)
+	}
+	if !HasDefaultRoute(routes) || HasDefaultRoute(routes[1:]) {
+		t.Errorf("default route not recognised")
+	}
+
+	if _, err := EncodeRoutes(append(routes, "10.8.0.1/16 via 10.0.0.2")); err == nil {
+		t.Errorf("route with bits beyond its prefix accepted")
+	}
+}
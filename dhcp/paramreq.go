@@ -0,0 +1,78 @@
+package dhcp
+
+import "pisa/packet"
+
+// Options sent whether the client asked for them or not, in this order.
+var mandatoryOptions = []uint8{
+	packet.OptionMessageType,
+	packet.OptionServerIdentifier,
+	packet.OptionLeaseTime,
+	packet.OptionRenewalTime,
+	packet.OptionRebindingTime,
+	packet.OptionSubnetMask,
+	packet.OptionMessage,
+}
+
+// Message size every client accepts, assumed when a client doesn't send option 57 (RFC 2131 2).
+const minimumMessageSize = 576
+
+// IP and UDP headers, the maximum message size covers them (RFC 2132 9.10).
+const ipUDPHeaders = 20 + 8
+
+// Returns the largest message the client that sent a request accepts.
+func maxMessageSize(request *packet.Packet) int {
+	size, ok := request.Options.Uint16(packet.OptionMaxMessageSize)
+	if !ok || int(size) < minimumMessageSize {
+		return minimumMessageSize
+	}
+	return int(size)
+}
+
+// Picks the options of a reply from the Parameter Request List (option 55) of the request.
+//
+// The mandatory options come first, followed by the requested ones in the order they
+// were asked for. Options nobody asked for are dropped, clients that don't send a
+// list get everything. Relay agent information stays last.
+//
+// Options that don't fit into the client's maximum message size (option 57), even
+// with the file and sname fields overloaded, are dropped, starting with the ones asked for last.
+func selectOptions(request *packet.Packet, reply *packet.Packet) {
+	var selected packet.Options
+	for _, code := range mandatoryOptions {
+		if data, ok := reply.Options.Get(code); ok {
+			selected = append(selected, packet.Option{Code: code, Data: data})
+		}
+	}
+	mandatory := len(selected)
+
+	requested, hasList := request.Options.Get(packet.OptionParameterRequestList)
+	if !hasList {
+		for _, opt := range reply.Options {
+			if !selected.Has(opt.Code) && opt.Code != packet.OptionRelayAgentInfo {
+				selected = append(selected, opt)
+			}
+		}
+	}
+	for _, code := range requested {
+		data, ok := reply.Options.Get(code)
+		if ok && !selected.Has(code) && code != packet.OptionRelayAgentInfo {
+			selected = append(selected, packet.Option{Code: code, Data: data})
+		}
+	}
+
+	// Relay agent information has to be last and is never dropped.
+	var last packet.Options
+	if data, ok := reply.Options.Get(packet.OptionRelayAgentInfo); ok {
+		last = packet.Options{{Code: packet.OptionRelayAgentInfo, Data: data}}
+	}
+
+	// Options overflow into file and sname before any are dropped.
+	reply.MaxSize = maxMessageSize(request) - ipUDPHeaders
+	for {
+		reply.Options = append(selected[:len(selected):len(selected)], last...)
+		if _, err := reply.MarshalBinary(); err == nil || len(selected) == mandatory {
+			return
+		}
+		selected = selected[:len(selected)-1]
+	}
+}
@@ -0,0 +1,94 @@
+package dhcp
+
+import (
+	"bytes"
+	"pisa/packet"
+	"testing"
+)
+
+// Returns the codes of options in order.
+func optionCodes(options packet.Options) []uint8 {
+	codes := make([]uint8, len(options))
+	for i, opt := range options {
+		codes[i] = opt.Code
+	}
+	return codes
+}
+
+// Returns a request with a parameter request list and maximum message size, zero leaves them out.
+func paramRequest(list []uint8, maxSize uint16) *packet.Packet {
+	p := &packet.Packet{TransactionID: []byte{1, 2, 3, 4}, ClientMAC: []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55}}
+	if list != nil {
+		p.Options.Set(packet.OptionParameterRequestList, list)
+	}
+	if maxSize != 0 {
+		p.Options.SetUint16(packet.OptionMaxMessageSize, maxSize)
+	}
+	return p
+}
+
+func TestSelectOptions(t *testing.T) {
+	// Options of the subnet, not in the order they are sent.
+	var subnetOptions packet.Options
+	subnetOptions.SetIPs(packet.OptionRouter, [][]byte{{10, 0, 0, 1}})
+	subnetOptions.SetIPs(packet.OptionDNS, [][]byte{{1, 1, 1, 1}})
+	subnetOptions.SetIP(packet.OptionSubnetMask, []byte{255, 255, 255, 0})
+	subnetOptions.SetUint32(packet.OptionLeaseTime, 3600)
+	subnetOptions.SetUint32(packet.OptionRenewalTime, 1800)
+	subnetOptions.SetUint32(packet.OptionRebindingTime, 2970)
+	subnetOptions.Set(43, bytes.Repeat([]byte{'v'}, 250))
+	subnetOptions.Set(119, bytes.Repeat([]byte{'d'}, 250))
+	subnet := &Subnet{parsedOptions: subnetOptions}
+	s := &DHCPServer{LocalAddress: []byte{10, 0, 0, 2}}
+
+	mandatory := []uint8{53, 54, 51, 58, 59, 1}
+	tests := []struct {
+		name    string
+		list    []uint8
+		maxSize uint16
+		want    []uint8
+		limit   int
+	}{
+		{"requested order", []uint8{6, 3, 99}, 0, []uint8{6, 3}, 548},
+		{"no list", nil, 1500, []uint8{3, 6, 43, 119}, 1472},
+		{"duplicates and mandatory asked for", []uint8{3, 1, 3, 54}, 0, []uint8{3}, 548},
+		{"everything fits", []uint8{3, 6, 43, 119}, 1500, []uint8{3, 6, 43, 119}, 1472},
+		{"asked for last dropped first", []uint8{3, 6, 43, 119}, 0, []uint8{3, 6, 43}, 548},
+		{"maximum below 576", []uint8{119, 43, 3}, 300, []uint8{119}, 548},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := paramRequest(tt.list, tt.maxSize)
+			reply := s.newReply(request, subnet, packet.DHCPAck, 0x0a000005)
+			reply.Options.Set(packet.OptionRelayAgentInfo, []byte{packet.SubOptionCircuitID, 2, 'p', '1'})
+
+			selectOptions(request, reply)
+			want := append(append(mandatory[:len(mandatory):len(mandatory)], tt.want...), packet.OptionRelayAgentInfo)
+			if got := optionCodes(reply.Options); !bytes.Equal(got, want) {
+				t.Errorf("options = %v, want %v", got, want)
+			}
+			if reply.MaxSize != tt.limit {
+				t.Errorf("max size = %d, want %d", reply.MaxSize, tt.limit)
+			}
+			if _, err := reply.MarshalBinary(); err != nil {
+				t.Errorf("selected options don't fit: %v", err)
+			}
+		})
+	}
+}
+
+func TestSelectOptionsKeepsMandatory(t *testing.T) {
+	// With a long message no optional option fits, the mandatory ones are kept.
+	reply := &packet.Packet{TransactionID: []byte{1, 2, 3, 4}}
+	reply.Options.SetUint8(packet.OptionMessageType, packet.DHCPNak)
+	reply.Options.SetIP(packet.OptionServerIdentifier, []byte{10, 0, 0, 2})
+	for _, code := range []uint8{43, 44, 45} {
+		reply.Options.Set(code, bytes.Repeat([]byte{1}, 255))
+	}
+	reply.Options.Set(packet.OptionMessage, bytes.Repeat([]byte{'m'}, 255))
+
+	selectOptions(paramRequest([]uint8{43, 44, 45}, 0), reply)
+	if got, want := optionCodes(reply.Options), []uint8{53, 54, 56}; !bytes.Equal(got, want) {
+		t.Errorf("options = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package dhcp
+
+import (
+	"fmt"
+	"pisa/lease"
+	"pisa/packet"
+	"pisa/util"
+)
+
+// Identity policies, how the pool recognises a client.
+const (
+	// Client identifier (option 61) when the client sends one, MAC otherwise.
+	IdentityClientID = "clientid"
+	// MAC address only.
+	IdentityMAC = "mac"
+)
+
+// Range of addresses handed out by the server.
+type Pool struct {
+	// First address assignable
+	First uint32
+	// Last address assignable
+	Last uint32
+
+	// Picks addresses out of the range.
+	Allocator Allocator
+
+	// Identity policy.
+	Identity string
+
+	// Circuit-ID and Remote-ID (option 82) a client has to be behind to get an address
+	// from the pool, empty values match anything.
+	CircuitID []byte
+	RemoteID  []byte
+}
+
+// Creates a pool for the range first-last using an allocation strategy and identity policy.
+func NewPool(first uint32, last uint32, strategy string, identity string) (*Pool, error) {
+	switch identity {
+	case "":
+		identity = IdentityClientID
+	case IdentityClientID, IdentityMAC:
+	default:
+		return nil, fmt.Errorf("unknown identity policy: %s", identity)
+	}
+
+	allocator, err := NewAllocator(strategy, first, last)
+	if err != nil {
+		return nil, err
+	}
+	return &Pool{
+		First:     first,
+		Last:      last,
+		Allocator: allocator,
+		Identity:  identity,
+	}, nil
+}
+
+// Returns the range of the pool.
+func (p *Pool) String() string {
+	return util.Uint32IntoAddress(p.First) + "-" + util.Uint32IntoAddress(p.Last)
+}
+
+// Reports whether an address belongs to the pool.
+func (p *Pool) Contains(address uint32) bool {
+	return address >= p.First && address <= p.Last
+}
+
+// Reports whether the pool is limited to clients behind some relay agent port.
+func (pool *Pool) restricted() bool {
+	return len(pool.CircuitID) > 0 || len(pool.RemoteID) > 0
+}
+
+// Reports whether the client that sent a packet may get an address from the pool.
+func (pool *Pool) admits(p *packet.Packet) bool {
+	return relayInfoMatches(pool.CircuitID, pool.RemoteID, p)
+}
+
+// Returns the key the pool knows the client that sent a packet by.
+func (pool *Pool) clientKey(p *packet.Packet) string {
+	if id := identityClientID(pool.Identity, p); id != "" {
+		return "id:" + id
+	}
+	return p.StringMAC
+}
+
+// Returns the client identifier of a packet if the identity policy recognises clients by it.
+func identityClientID(identity string, p *packet.Packet) string {
+	if identity == IdentityMAC {
+		return ""
+	}
+	return clientID(p)
+}
+
+// Reports whether a lease belongs to the client that sent a packet under an identity policy.
+//
+// Clients with an identifier also own leases recorded by MAC before they sent one.
+func owns(identity string, l *lease.Lease, p *packet.Packet) bool {
+	if id := identityClientID(identity, p); id != "" {
+		return l.ClientID == id || (l.ClientID == "" && l.MAC == p.StringMAC)
+	}
+	return l.MAC == p.StringMAC
+}
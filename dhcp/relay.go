@@ -0,0 +1,178 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"pisa/packet"
+	"pisa/util"
+	"strings"
+)
+
+// Relays give up on requests that went through this many relays already (RFC 1542).
+const maxHops = 16
+
+// Network of the relay agent that clients are connected to.
+type relayInterface struct {
+	device  *net.Interface
+	address []byte
+}
+
+// Struct representing the DHCP relay agent.
+//
+// Requests of clients are forwarded to the upstream servers with giaddr set
+// to the address of the interface they arrived on, replies are passed back
+// to the clients on that interface.
+type DHCPRelay struct {
+	Conn    *net.UDPConn
+	Options *DHCPOptions
+	Buffer  []byte
+	// Buffer for control messages (IP_PKTINFO).
+	oob []byte
+
+	// Client interfaces by index.
+	interfaces map[int]*relayInterface
+	// Servers requests are forwarded to.
+	upstream []*net.UDPAddr
+}
+
+// Start relay agent.
+//
+// Requires a map of options, Interface is a comma separated list of client interfaces.
+func StartRelay(opt *DHCPOptions) *DHCPRelay {
+	if len(opt.Upstream) == 0 {
+		util.OnError(fmt.Errorf("no upstream server provided"))
+	}
+
+	r := &DHCPRelay{
+		Options:    opt,
+		Buffer:     make([]byte, 1500),
+		oob:        make([]byte, 128),
+		interfaces: make(map[int]*relayInterface),
+	}
+
+	for _, name := range strings.Split(opt.Interface, ",") {
+		device, err := net.InterfaceByName(name)
+		util.OnError(err)
+		networks := interfaceNetworks(device)
+		if len(networks) == 0 {
+			util.OnError(fmt.Errorf("no IPv4 address on interface %s", name))
+		}
+		r.interfaces[device.Index] = &relayInterface{device: device, address: []byte(networks[0].IP)}
+		log.Println("Relaying clients on", name, "with address", networks[0].IP.String())
+	}
+
+	for _, server := range opt.Upstream {
+		if !util.CheckAddress(server) {
+			util.OnError(fmt.Errorf("invalid upstream server: %s", server))
+		}
+		r.upstream = append(r.upstream, &net.UDPAddr{IP: net.ParseIP(server), Port: 67})
+	}
+
+	// Connection
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{
+		Port: 67,
+		IP:   net.ParseIP("0.0.0.0"),
+	})
+	util.OnError(err)
+	util.OnError(enablePacketInfo(conn))
+	r.Conn = conn
+
+	log.Println("Started relay agent, forwarding to", strings.Join(opt.Upstream, ", "))
+	return r
+}
+
+// Reads a single datagram from the connection (Port 67).
+func (r *DHCPRelay) Read() (*Datagram, error) {
+	return readDatagram(r.Conn, r.Buffer, r.oob)
+}
+
+// Passes a packet on, requests go to the servers and replies to the clients.
+func (r *DHCPRelay) Relay(p *packet.Packet) error {
+	switch p.Opcode {
+	case packet.BootRequest:
+		return r.forwardRequest(p)
+	case packet.BootReply:
+		return r.forwardReply(p)
+	}
+	return fmt.Errorf("unknown opcode %d from %s", p.Opcode, p.StringMAC)
+}
+
+// Forwards a client's request to every upstream server (RFC 1542 4.1.1).
+func (r *DHCPRelay) forwardRequest(p *packet.Packet) error {
+	if p.Hops >= maxHops {
+		return fmt.Errorf("dropping request from %s: too many hops", p.StringMAC)
+	}
+
+	// Requests relayed by another agent already carry its giaddr and are passed on as they are.
+	if !p.Relayed() {
+		iface, ok := r.interfaces[p.Interface]
+		if !ok {
+			// Not one of the client networks, possibly our own traffic.
+			return nil
+		}
+		if p.RelayInfo != nil {
+			// Option 82 from a client can't be trusted (RFC 3046 2.1).
+			return fmt.Errorf("dropping request from %s: relay agent information without giaddr", p.StringMAC)
+		}
+		p.GatewayAddress = binary.BigEndian.Uint32(iface.address)
+
+		if r.Options.RelayAgentInfo {
+			info := &packet.RelayAgentInfo{
+				CircuitID: []byte(iface.device.Name),
+				RemoteID:  []byte(r.Options.RemoteID),
+			}
+			p.Options.Set(packet.OptionRelayAgentInfo, info.Encode())
+		}
+	}
+	p.Hops++
+
+	data, err := p.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	for _, server := range r.upstream {
+		if _, err := r.Conn.WriteToUDP(data, server); err != nil {
+			return err
+		}
+	}
+	log.Println("Forwarded request from", p.StringMAC, "to", len(r.upstream), "servers")
+	return nil
+}
+
+// Passes a server's reply back to the client it is meant for (RFC 1542 4.1.2).
+func (r *DHCPRelay) forwardReply(p *packet.Packet) error {
+	iface := r.interfaceOf(p.GatewayAddress)
+	if iface == nil {
+		return fmt.Errorf("dropping reply for %s: giaddr %s is not ours", p.StringMAC, util.Uint32IntoAddress(p.GatewayAddress))
+	}
+
+	// The information was only meant for the server.
+	if r.Options.RelayAgentInfo {
+		p.Options.Delete(packet.OptionRelayAgentInfo)
+	}
+	// The server fit the reply into what the client accepts, options it
+	// overloaded into file and sname stay there.
+	p.MaxSize = max(len(p.Payload), minimumMessageSize-ipUDPHeaders)
+
+	switch {
+	case !hasEthernetAddress(p):
+		return sendFrameOn(iface.device, iface.address, p, broadcastAddress, broadcastMAC)
+	case p.ClientAddress != 0:
+		return sendFrameOn(iface.device, iface.address, p, util.Uint32Bytes(p.ClientAddress), p.ClientMAC)
+	case p.Flags&broadcastFlag != 0:
+		return sendFrameOn(iface.device, iface.address, p, broadcastAddress, broadcastMAC)
+	}
+	return sendFrameOn(iface.device, iface.address, p, util.Uint32Bytes(p.YourAddress), p.ClientMAC)
+}
+
+// Returns the client interface with an address or nil.
+func (r *DHCPRelay) interfaceOf(address uint32) *relayInterface {
+	for _, iface := range r.interfaces {
+		if binary.BigEndian.Uint32(iface.address) == address {
+			return iface
+		}
+	}
+	return nil
+}
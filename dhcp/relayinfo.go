@@ -0,0 +1,51 @@
+package dhcp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"pisa/packet"
+	"strings"
+)
+
+// Parses a Circuit-ID or Remote-ID from the configuration.
+//
+// Values starting with 0x are hex, anything else is taken as text.
+func ParseRelayID(value string) ([]byte, error) {
+	if h, ok := strings.CutPrefix(value, "0x"); ok {
+		id, err := hex.DecodeString(h)
+		if err != nil {
+			return nil, fmt.Errorf("invalid hex relay agent id: %s", value)
+		}
+		value = string(id)
+	}
+	if value == "" || len(value) > 255 {
+		return nil, fmt.Errorf("invalid relay agent id: %s", value)
+	}
+	return []byte(value), nil
+}
+
+// Reports whether the relay agent information of a packet matches a Circuit-ID and Remote-ID.
+//
+// Empty values match anything.
+func relayInfoMatches(circuitID []byte, remoteID []byte, p *packet.Packet) bool {
+	if len(circuitID) == 0 && len(remoteID) == 0 {
+		return true
+	}
+	if p.RelayInfo == nil {
+		return false
+	}
+	return (len(circuitID) == 0 || bytes.Equal(circuitID, p.RelayInfo.CircuitID)) &&
+		(len(remoteID) == 0 || bytes.Equal(remoteID, p.RelayInfo.RemoteID))
+}
+
+// Echoes the relay agent information of a request in its reply (RFC 3046).
+//
+// The option is placed last, right before End.
+func echoRelayInfo(request *packet.Packet, reply *packet.Packet) {
+	if request.RelayInfo == nil {
+		return
+	}
+	reply.Options.Delete(packet.OptionRelayAgentInfo)
+	reply.Options.Set(packet.OptionRelayAgentInfo, request.RelayInfo.Raw)
+}
@@ -0,0 +1,85 @@
+package dhcp
+
+import (
+	"log"
+	"pisa/lease"
+	"pisa/packet"
+	"pisa/util"
+	"time"
+)
+
+// Handles a DHCPRELEASE.
+//
+// The binding is freed right away, its address can be handed out again.
+func (s *DHCPServer) Release(p *packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.addressedToUs(p) {
+		log.Println("Ignoring DHCPRELEASE from", p.StringMAC, "for another server")
+		return nil
+	}
+
+	subnet := s.subnetOf(p.ClientAddress)
+	if subnet == nil {
+		return nil
+	}
+	l, ok := s.clientLease(p, subnet)
+	if !ok || l.Address != p.ClientAddress {
+		log.Println("DHCPRELEASE from", p.StringMAC, "for", util.Uint32IntoAddress(p.ClientAddress), "doesn't match a lease")
+		return nil
+	}
+
+	l.State = lease.Released
+	l.Expiry = time.Now()
+	err := s.Leases.Put(l)
+	if err != nil {
+		return err
+	}
+	if pool := subnet.poolOf(l.Address); pool != nil {
+		pool.Allocator.Release(l.Address)
+	}
+
+	log.Println("Released", util.Uint32IntoAddress(l.Address), "from", p.StringMAC)
+	return nil
+}
+
+// Handles a DHCPDECLINE.
+//
+// The client found the address in use by someone else, so the address is
+// quarantined for the probation period before it goes back to the pool.
+func (s *DHCPServer) Decline(p *packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.addressedToUs(p) {
+		log.Println("Ignoring DHCPDECLINE from", p.StringMAC, "for another server")
+		return nil
+	}
+
+	declined, ok := p.Options.Uint32(packet.OptionRequestedAddress)
+	if !ok {
+		log.Println("Ignoring DHCPDECLINE without an address from", p.StringMAC)
+		return nil
+	}
+
+	subnet := s.subnetOf(declined)
+	if subnet == nil {
+		return nil
+	}
+	l, ok := s.clientLease(p, subnet)
+	if !ok || l.Address != declined {
+		log.Println("DHCPDECLINE from", p.StringMAC, "for", util.Uint32IntoAddress(declined), "doesn't match a lease")
+		return nil
+	}
+
+	l.State = lease.Declined
+	l.Expiry = time.Now().Add(time.Duration(s.Options.Probation) * time.Second)
+	err := s.Leases.Put(l)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Declined", util.Uint32IntoAddress(l.Address), "by", p.StringMAC, "- quarantined until", l.Expiry.Format(time.DateTime))
+	return nil
+}
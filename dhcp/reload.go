@@ -0,0 +1,158 @@
+package dhcp
+
+import (
+	"bytes"
+	"log"
+	"pisa/lease"
+	"pisa/util"
+	"slices"
+)
+
+// Swaps in a new configuration without restarting the server.
+//
+// The subnets are checked before anything changes, on error the old configuration
+// stays in place. Leases that still fit the new configuration are kept, the others
+// are released. Settings that need a restart are logged and left alone.
+func (s *DHCPServer) Reload(opt *DHCPOptions, subnets []*Subnet, reservations []*Reservation) error {
+	err := prepareSubnets(subnets, s.localNetwork)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if opt.Probation == 0 {
+		opt.Probation = DefaultProbation
+	}
+	if opt.LeaseFile == "" {
+		opt.LeaseFile = DefaultLeaseFile
+	}
+	s.logChanges(opt, subnets, reservations)
+
+	s.Options.Grace = opt.Grace
+	s.Options.Probation = opt.Probation
+	s.Options.Authoritative = opt.Authoritative
+	carryOverHistory(s.Subnets, subnets)
+	s.Subnets = subnets
+	s.Reservations = reservations
+
+	for _, l := range s.Leases.All() {
+		if !l.Active() || s.leaseFits(&l) {
+			continue
+		}
+		log.Println("Reload: releasing", util.Uint32IntoAddress(l.Address), "of", l.MAC, "as it no longer fits the configuration")
+		l.State = lease.Released
+		util.NonFatalError(s.Leases.Put(l))
+	}
+	return nil
+}
+
+// Reports whether a lease fits the configuration, the address has to be in a
+// pool or reserved for the lease's holder.
+func (s *DHCPServer) leaseFits(l *lease.Lease) bool {
+	for _, r := range s.Reservations {
+		if r.Address == l.Address {
+			return r.ownsLease(l) && s.subnetOf(l.Address) != nil
+		}
+	}
+	return s.poolOf(l.Address) != nil
+}
+
+// Logs what a new configuration changes.
+func (s *DHCPServer) logChanges(opt *DHCPOptions, subnets []*Subnet, reservations []*Reservation) {
+	// Settings of the server
+	if opt.Interface != s.Options.Interface || opt.LeaseFile != s.Options.LeaseFile ||
+		opt.Mode != s.Options.Mode || opt.PidFile != s.Options.PidFile {
+		log.Println("Reload: interface, leasefile, mode and pidfile only change on restart")
+	}
+	if opt.Authoritative != s.Options.Authoritative {
+		log.Println("Reload: authoritative", s.Options.Authoritative, "->", opt.Authoritative)
+	}
+	if opt.Grace != s.Options.Grace {
+		log.Println("Reload: grace", s.Options.Grace, "->", opt.Grace)
+	}
+	if opt.Probation != s.Options.Probation {
+		log.Println("Reload: probation", s.Options.Probation, "->", opt.Probation)
+	}
+
+	// Subnets
+	for _, subnet := range subnets {
+		old := findSubnet(s.Subnets, subnet.String())
+		if old == nil {
+			log.Println("Reload: added subnet", subnet.String())
+			continue
+		}
+		oldPools, newPools := poolRanges(old), poolRanges(subnet)
+		for _, r := range newPools {
+			if !slices.Contains(oldPools, r) {
+				log.Println("Reload: added pool", r, "to", subnet.String())
+			}
+		}
+		for _, r := range oldPools {
+			if !slices.Contains(newPools, r) {
+				log.Println("Reload: removed pool", r, "from", subnet.String())
+			}
+		}
+		if !bytes.Equal(old.parsedOptions.Encode(), subnet.parsedOptions.Encode()) {
+			log.Println("Reload: changed options of", subnet.String())
+		}
+	}
+	for _, subnet := range s.Subnets {
+		if findSubnet(subnets, subnet.String()) == nil {
+			log.Println("Reload: removed subnet", subnet.String())
+		}
+	}
+
+	// Reservations
+	for _, r := range reservations {
+		old := findReservation(s.Reservations, r.Address)
+		switch {
+		case old == nil:
+			log.Println("Reload: added reservation of", util.Uint32IntoAddress(r.Address))
+		case !sameReservation(old, r):
+			log.Println("Reload: changed reservation of", util.Uint32IntoAddress(r.Address))
+		}
+	}
+	for _, r := range s.Reservations {
+		if findReservation(reservations, r.Address) == nil {
+			log.Println("Reload: removed reservation of", util.Uint32IntoAddress(r.Address))
+		}
+	}
+}
+
+// Returns the subnet with a network in CIDR notation or nil.
+func findSubnet(subnets []*Subnet, network string) *Subnet {
+	for _, subnet := range subnets {
+		if subnet.String() == network {
+			return subnet
+		}
+	}
+	return nil
+}
+
+// Returns the ranges of a subnet's pools.
+func poolRanges(subnet *Subnet) []string {
+	ranges := make([]string, len(subnet.Pools))
+	for i, pool := range subnet.Pools {
+		ranges[i] = pool.String()
+	}
+	return ranges
+}
+
+// Returns the reservation of an address or nil.
+func findReservation(reservations []*Reservation, address uint32) *Reservation {
+	for _, r := range reservations {
+		if r.Address == address {
+			return r
+		}
+	}
+	return nil
+}
+
+// Reports whether two reservations are the same.
+func sameReservation(a *Reservation, b *Reservation) bool {
+	return a.MAC == b.MAC && a.ClientID == b.ClientID &&
+		bytes.Equal(a.CircuitID, b.CircuitID) && bytes.Equal(a.RemoteID, b.RemoteID) &&
+		a.Hostname == b.Hostname && bytes.Equal(a.Options.Encode(), b.Options.Encode())
+}
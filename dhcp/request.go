@@ -0,0 +1,141 @@
+package dhcp
+
+import (
+	"log"
+	"pisa/lease"
+	"pisa/packet"
+	"pisa/util"
+)
+
+// States a client sends a DHCPREQUEST from (RFC 2131 4.3.2).
+type requestState int
+
+const (
+	// Answering an offer, carries the server identifier and requested address.
+	selecting requestState = iota
+	// Verifying a previous address after a reboot, carries the requested address.
+	initReboot
+	// Extending its lease, unicast to the server with ciaddr set.
+	renewing
+	// Extending its lease with any server, broadcast with ciaddr set.
+	rebinding
+)
+
+func (r requestState) String() string {
+	switch r {
+	case selecting:
+		return "SELECTING"
+	case initReboot:
+		return "INIT-REBOOT"
+	case renewing:
+		return "RENEWING"
+	}
+	return "REBINDING"
+}
+
+// Determines the state a client sent a DHCPREQUEST from.
+func requestStateOf(p *packet.Packet) requestState {
+	switch {
+	case p.Options.Has(packet.OptionServerIdentifier):
+		return selecting
+	case p.ClientAddress == 0:
+		return initReboot
+	// Renewals go straight to the server, only broadcasts get relayed.
+	case p.Destination == 0xffffffff || p.Relayed():
+		return rebinding
+	}
+	return renewing
+}
+
+// Reports whether an address in a subnet may be leased to the client that sent a packet.
+func (s *DHCPServer) validAddress(p *packet.Packet, subnet *Subnet, address uint32) bool {
+	if r := s.reservation(p, subnet); r != nil {
+		return address == r.Address
+	}
+	pool := subnet.poolOf(address)
+	return pool != nil && pool.admits(p) && !s.reserved(address)
+}
+
+// Handles a DHCPREQUEST.
+//
+// Only requests for a valid binding of this client are acknowledged,
+// the server stays silent when the client selected another server.
+//
+// Requests for a wrong address are NAKed, requests from clients the server
+// has no record of only when it is authoritative.
+func (s *DHCPServer) HandleRequest(p *packet.Packet) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := requestStateOf(p)
+	subnet := s.subnetFor(p)
+	if subnet == nil {
+		log.Println("No subnet for", state, "DHCPREQUEST from", p.StringMAC)
+		return nil
+	}
+	l, hasLease := s.clientLease(p, subnet)
+	requested, hasRequested := p.Options.Uint32(packet.OptionRequestedAddress)
+
+	switch state {
+	case selecting:
+		if !s.addressedToUs(p) {
+			// Client took an offer of another server, our offer is free again.
+			if hasLease && l.State == lease.Offered {
+				log.Println("DHCPREQUEST from", p.StringMAC, "selected another server")
+				l.State = lease.Released
+				return s.Leases.Put(l)
+			}
+			return nil
+		}
+		if !hasRequested || p.ClientAddress != 0 {
+			log.Println("Ignoring malformed", state, "DHCPREQUEST from", p.StringMAC)
+			return nil
+		}
+		if !hasLease || l.Address != requested {
+			return s.SendDHCPNak(p, "requested address doesn't match the offer")
+		}
+
+	case initReboot:
+		if !hasRequested {
+			log.Println("Ignoring malformed", state, "DHCPREQUEST from", p.StringMAC)
+			return nil
+		}
+		// No record of the client, another server may know it.
+		if !hasLease {
+			if s.Options.Authoritative && !s.validAddress(p, subnet, requested) {
+				return s.SendDHCPNak(p, "requested address is not on this network")
+			}
+			// The client would keep using an address that belongs to someone else.
+			if other, ok := s.Leases.Get(requested); s.Options.Authoritative && ok && other.Active() && !subnet.owns(&other, p) {
+				return s.SendDHCPNak(p, "requested address is leased to another client")
+			}
+			return nil
+		}
+		if l.Address != requested {
+			return s.SendDHCPNak(p, "requested address doesn't match the lease")
+		}
+
+	case renewing, rebinding:
+		// Renewals are sent to the server identifier of the lease.
+		if !s.addressedToUs(p) {
+			return nil
+		}
+		if !hasLease || l.State == lease.Offered || l.Address != p.ClientAddress {
+			if s.Options.Authoritative {
+				return s.SendDHCPNak(p, "no lease for "+util.Uint32IntoAddress(p.ClientAddress))
+			}
+			return nil
+		}
+	}
+
+	if !s.validAddress(p, subnet, l.Address) {
+		log.Println("Lease of", p.StringMAC, "on", util.Uint32IntoAddress(l.Address), "is no longer valid")
+		l.State = lease.Released
+		err := s.Leases.Put(l)
+		util.NonFatalError(err)
+		return s.SendDHCPNak(p, "lease is no longer valid")
+	}
+
+	log.Println("Received", state, "DHCPREQUEST from", p.StringMAC)
+	return s.sendAck(p, subnet, l)
+}
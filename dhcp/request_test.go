@@ -0,0 +1,56 @@
+package dhcp
+
+import (
+	"pisa/packet"
+	"testing"
+)
+
+func TestRequestStateOf(t *testing.T) {
+	tests := []struct {
+		name        string
+		serverID    bool
+		ciaddr      uint32
+		giaddr      uint32
+		destination uint32
+		want        requestState
+	}{
+		{"answering an offer", true, 0, 0, 0xffffffff, selecting},
+		{"answering a relayed offer", true, 0, 0x0a000001, 0x0a000002, selecting},
+		{"after a reboot", false, 0, 0, 0xffffffff, initReboot},
+		{"relayed after a reboot", false, 0, 0x0a000001, 0x0a000002, initReboot},
+		{"renewing", false, 0x0a000005, 0, 0x0a000002, renewing},
+		{"rebinding", false, 0x0a000005, 0, 0xffffffff, rebinding},
+		{"relayed rebinding", false, 0x0a000005, 0x0a000001, 0x0a000002, rebinding},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &packet.Packet{ClientAddress: tt.ciaddr, GatewayAddress: tt.giaddr, Destination: tt.destination}
+			if tt.serverID {
+				p.Options.SetIP(packet.OptionServerIdentifier, []byte{10, 0, 0, 2})
+			}
+			if got := requestStateOf(p); got != tt.want {
+				t.Errorf("state = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewReplyClientAddress(t *testing.T) {
+	s := &DHCPServer{LocalAddress: []byte{10, 0, 0, 2}}
+	request := &packet.Packet{TransactionID: []byte{1, 2, 3, 4}, ClientAddress: 0x0a000005}
+
+	// Only a DHCPACK echoes ciaddr.
+	for messageType, want := range map[uint8]uint32{
+		packet.DHCPAck:   0x0a000005,
+		packet.DHCPOffer: 0,
+		packet.DHCPNak:   0,
+	} {
+		reply := s.newReply(request, nil, messageType, 0x0a000005)
+		if reply.ClientAddress != want {
+			t.Errorf("message type %d: ciaddr = %x, want %x", messageType, reply.ClientAddress, want)
+		}
+		if id, _ := reply.Options.IP(packet.OptionServerIdentifier); string(id) != string(s.LocalAddress) {
+			t.Errorf("message type %d: server identifier = %v", messageType, id)
+		}
+	}
+}
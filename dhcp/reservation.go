@@ -0,0 +1,148 @@
+package dhcp
+
+import (
+	"encoding/hex"
+	"fmt"
+	"pisa/lease"
+	"pisa/packet"
+	"pisa/util"
+	"strings"
+)
+
+// Fixed address for a host, matched by MAC, client identifier or
+// the switch port it is connected to (option 82).
+type Reservation struct {
+	// MAC address as a hex string.
+	MAC string
+	// Client identifier (option 61) as a hex string.
+	ClientID string
+	// Circuit-ID and Remote-ID the relay agent has to report.
+	CircuitID []byte
+	RemoteID  []byte
+
+	Address  uint32
+	Hostname string
+
+	// Options sent to the host instead of the configured ones.
+	Options packet.Options
+}
+
+// Parses a MAC address from the configuration into the form reservations are matched by.
+func ParseMAC(value string) (string, error) {
+	mac, err := parseHex(value)
+	if err != nil || len(mac) != 12 {
+		return "", fmt.Errorf("invalid MAC address: %s", value)
+	}
+	return mac, nil
+}
+
+// Parses a client identifier (option 61) from the configuration, given in hex.
+func ParseClientID(value string) (string, error) {
+	id, err := parseHex(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid client identifier: %s", value)
+	}
+	return id, nil
+}
+
+// Decodes a hex string, colons and dashes between bytes are allowed.
+func parseHex(s string) (string, error) {
+	s = strings.ToLower(strings.NewReplacer(":", "", "-", "").Replace(s))
+	if _, err := hex.DecodeString(s); err != nil || s == "" {
+		return "", fmt.Errorf("invalid hex string: %s", s)
+	}
+	return s, nil
+}
+
+// Reports whether a reservation is meant for the client that sent a packet.
+//
+// Reservations without a MAC or client identifier belong to a switch port
+// and match any client the relay agent reports on it.
+func (r *Reservation) Matches(p *packet.Packet) bool {
+	if !relayInfoMatches(r.CircuitID, r.RemoteID, p) {
+		return false
+	}
+	switch {
+	case r.ClientID != "":
+		return r.ClientID == clientID(p)
+	case r.MAC != "":
+		return r.MAC == p.StringMAC
+	}
+	return r.portBased()
+}
+
+// Reports whether a lease was handed out to the host of a reservation.
+//
+// Port based reservations can't tell, leases on them are assumed to fit.
+func (r *Reservation) ownsLease(l *lease.Lease) bool {
+	switch {
+	case r.ClientID != "":
+		return r.ClientID == l.ClientID
+	case r.MAC != "":
+		return r.MAC == l.MAC
+	}
+	return true
+}
+
+// Reports whether a reservation is only matched by relay agent information.
+func (r *Reservation) portBased() bool {
+	return r.ClientID == "" && r.MAC == "" && (len(r.CircuitID) > 0 || len(r.RemoteID) > 0)
+}
+
+// Adds the host's options to a reply.
+//
+// Does nothing for a nil reservation.
+func (r *Reservation) apply(reply *packet.Packet) {
+	if r == nil {
+		return
+	}
+	for _, opt := range r.Options {
+		reply.Options.Set(opt.Code, opt.Data)
+	}
+	if r.Hostname != "" {
+		reply.Options.SetString(packet.OptionHostname, r.Hostname)
+	}
+}
+
+// Returns the reservation in a subnet of the client that sent a packet or nil.
+func (s *DHCPServer) reservation(p *packet.Packet, subnet *Subnet) *Reservation {
+	for _, r := range s.Reservations {
+		if r.Matches(p) && subnet.Contains(r.Address) {
+			return r
+		}
+	}
+	return nil
+}
+
+// Reports whether an address is reserved for some host.
+func (s *DHCPServer) reserved(address uint32) bool {
+	for _, r := range s.Reservations {
+		if r.Address == address {
+			return true
+		}
+	}
+	return false
+}
+
+// Makes sure the reserved address can be leased to its host.
+//
+// A dynamic lease the host still holds is released. The address of a port
+// based reservation is taken over from the device that was on the port before.
+func (s *DHCPServer) moveToReservation(p *packet.Packet, subnet *Subnet, r *Reservation) error {
+	if l, ok := s.Leases.Get(r.Address); ok && l.Active() && !subnet.owns(&l, p) {
+		if !r.portBased() {
+			return fmt.Errorf("reserved address %s is leased to %s", util.Uint32IntoAddress(r.Address), l.MAC)
+		}
+		l.State = lease.Released
+		err := s.Leases.Put(l)
+		if err != nil {
+			return err
+		}
+	}
+
+	if l, ok := s.clientLease(p, subnet); ok && l.Address != r.Address {
+		l.State = lease.Released
+		return s.Leases.Put(l)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"net"
+	"pisa/packet"
+	"syscall"
+)
+
+// A datagram received on port 67.
+type Datagram struct {
+	Data []byte
+	// Sender of the datagram.
+	Source *net.UDPAddr
+	// Destination address from the IP header.
+	Destination uint32
+	// Index of the interface the datagram arrived on.
+	Interface int
+}
+
+// Asks the kernel to report the destination address and interface of every datagram.
+func enablePacketInfo(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_PKTINFO, 1)
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// Reads a single datagram from the connection (Port 67).
+func (s *DHCPServer) Read() (*Datagram, error) {
+	return readDatagram(s.SrvConn, s.Buffer, s.oob)
+}
+
+// Reads a datagram along with the packet information reported by the kernel.
+func readDatagram(conn *net.UDPConn, buffer []byte, oob []byte) (*Datagram, error) {
+	length, oobLength, _, source, err := conn.ReadMsgUDP(buffer, oob)
+	if err != nil {
+		return nil, err
+	}
+
+	d := &Datagram{
+		Data:   buffer[:length],
+		Source: source,
+	}
+
+	// struct in_pktinfo: interface index, local address, header destination address.
+	messages, _ := syscall.ParseSocketControlMessage(oob[:oobLength])
+	for _, m := range messages {
+		if m.Header.Level == syscall.IPPROTO_IP && m.Header.Type == syscall.IP_PKTINFO && len(m.Data) >= 12 {
+			d.Interface = int(binary.NativeEndian.Uint32(m.Data[0:4]))
+			d.Destination = binary.BigEndian.Uint32(m.Data[8:12])
+		}
+	}
+	return d, nil
+}
+
+// Parses the datagram into a packet carrying where it came from.
+func (d *Datagram) Packet() (*packet.Packet, error) {
+	p, err := packet.FromBytes(d.Data)
+	if err != nil {
+		return nil, err
+	}
+	p.Source = d.Source
+	p.Destination = d.Destination
+	p.Interface = d.Interface
+	return p, nil
+}
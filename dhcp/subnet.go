@@ -0,0 +1,244 @@
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"pisa/lease"
+	"pisa/packet"
+	"pisa/util"
+	"slices"
+	"time"
+)
+
+// Lease time used when none is configured, in seconds.
+const DefaultLease = 86400
+
+// Options of a network from the configuration file, reservations use them too.
+type SubnetOptions struct {
+	Router     []string
+	SubnetMask string
+	DNS        []string
+	TimeServer []string
+	// Classless static routes, "10.0.0.0/8 via 192.168.0.1".
+	Routes []string
+	Lease  uint
+	// Allocation strategy of the subnet's pools.
+	Allocator string
+	// Identity policy of the subnet's pools, "clientid" or "mac".
+	Identity string
+
+	// Any other options of the subnet, already encoded.
+	Extra packet.Options
+}
+
+// A network served by the server, with its own ranges and options.
+type Subnet struct {
+	// Network address
+	Network uint32
+	// Network mask, zero until the subnet of the interface is resolved.
+	Mask uint32
+
+	// Ranges of addresses handed out on the network.
+	Pools []*Pool
+
+	// Router, DNS, lease and other options of the network.
+	Options *SubnetOptions
+
+	// Options actually set in the configuration.
+	availableOptions []string
+
+	// Parsed options.
+	//
+	// This lets the server need to calculate (most) of the options only once.
+	//
+	// Stuff like addresses is dynamic ofc.
+	parsedOptions packet.Options
+}
+
+// Creates a subnet from CIDR notation (10.0.5.0/24).
+//
+// An empty network stands for the network of the server's interface.
+func NewSubnet(network string, opt *SubnetOptions, availableOptions []string) (*Subnet, error) {
+	subnet := &Subnet{
+		Options:          opt,
+		availableOptions: availableOptions,
+	}
+	if network == "" {
+		return subnet, nil
+	}
+
+	_, ipnet, err := net.ParseCIDR(network)
+	if err != nil || ipnet.IP.To4() == nil {
+		return nil, fmt.Errorf("invalid subnet: %s", network)
+	}
+	subnet.Network = binary.BigEndian.Uint32(ipnet.IP.To4())
+	subnet.Mask = binary.BigEndian.Uint32(ipnet.Mask)
+	return subnet, nil
+}
+
+// Adds a range of addresses using the subnet's allocation strategy and identity policy.
+func (n *Subnet) AddPool(first uint32, last uint32) (*Pool, error) {
+	pool, err := NewPool(first, last, n.Options.Allocator, n.Options.Identity)
+	if err != nil {
+		return nil, err
+	}
+	n.Pools = append(n.Pools, pool)
+	return pool, nil
+}
+
+// Returns the subnet in CIDR notation.
+func (n *Subnet) String() string {
+	ones, _ := net.IPMask(util.Uint32Bytes(n.Mask)).Size()
+	return fmt.Sprintf("%s/%d", util.Uint32IntoAddress(n.Network), ones)
+}
+
+// Reports whether an address belongs to the subnet.
+func (n *Subnet) Contains(address uint32) bool {
+	return address&n.Mask == n.Network
+}
+
+// Reports whether two subnets share addresses, one of them then holds the other.
+func (n *Subnet) Overlaps(other *Subnet) bool {
+	return n.Contains(other.Network) || other.Contains(n.Network)
+}
+
+// Returns the pool an address belongs to or nil.
+func (n *Subnet) poolOf(address uint32) *Pool {
+	for _, pool := range n.Pools {
+		if pool.Contains(address) {
+			return pool
+		}
+	}
+	return nil
+}
+
+// Reports whether a lease in the subnet belongs to the client that sent a packet.
+//
+// Leases outside of any pool (reserved addresses) use the default identity policy.
+func (n *Subnet) owns(l *lease.Lease, p *packet.Packet) bool {
+	identity := IdentityClientID
+	if pool := n.poolOf(l.Address); pool != nil {
+		identity = pool.Identity
+	}
+	return owns(identity, l, p)
+}
+
+// Checks the pools and prepares the options of the subnet.
+//
+// Every reply carries the subnet mask and a lease time, they are
+// filled in from the subnet when they aren't configured.
+func (n *Subnet) prepare() error {
+	for _, pool := range n.Pools {
+		if !n.Contains(pool.First) || !n.Contains(pool.Last) {
+			return fmt.Errorf("range %s-%s is outside of subnet %s",
+				util.Uint32IntoAddress(pool.First), util.Uint32IntoAddress(pool.Last), n)
+		}
+	}
+
+	if !slices.Contains(n.availableOptions, "subnetmask") {
+		n.Options.SubnetMask = util.Uint32IntoAddress(n.Mask)
+		n.availableOptions = append(n.availableOptions, "subnetmask")
+	}
+	if n.Options.Lease == 0 {
+		n.Options.Lease = DefaultLease
+	}
+	if !slices.Contains(n.availableOptions, "lease") {
+		n.availableOptions = append(n.availableOptions, "lease")
+	}
+
+	n.parsedOptions = BuildOptions(n.Options, n.availableOptions)
+	return nil
+}
+
+// Resolves the subnet of the interface and prepares every subnet.
+//
+// The network of the interface isn't known when the configuration is read,
+// a configured subnet overlapping it is only found here.
+func prepareSubnets(subnets []*Subnet, local *net.IPNet) error {
+	for _, subnet := range subnets {
+		if subnet.Mask != 0 {
+			continue
+		}
+		subnet.Mask = binary.BigEndian.Uint32(local.Mask)
+		subnet.Network = binary.BigEndian.Uint32(local.IP) & subnet.Mask
+		for _, other := range subnets {
+			if other != subnet && subnet.Overlaps(other) {
+				return fmt.Errorf("subnet %s of the interface overlaps configured subnet %s", subnet, other)
+			}
+		}
+	}
+	for _, subnet := range subnets {
+		if err := subnet.prepare(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Returns the lease time of the subnet as a duration.
+func (n *Subnet) leaseDuration() time.Duration {
+	return time.Duration(n.Options.Lease) * time.Second
+}
+
+// Returns the subnet an address belongs to or nil.
+func (s *DHCPServer) subnetOf(address uint32) *Subnet {
+	for _, subnet := range s.Subnets {
+		if subnet.Contains(address) {
+			return subnet
+		}
+	}
+	return nil
+}
+
+// Returns the pool an address belongs to or nil.
+func (s *DHCPServer) poolOf(address uint32) *Pool {
+	if subnet := s.subnetOf(address); subnet != nil {
+		return subnet.poolOf(address)
+	}
+	return nil
+}
+
+// Picks the subnet a request comes from or nil if the server doesn't serve it.
+//
+// Option 118 (subnet selection) wins, then the address of the relay (giaddr),
+// then the addresses of the configured interface. Unicasts routed in from another
+// interface belong to the subnet of the client's address (ciaddr).
+func (s *DHCPServer) subnetFor(p *packet.Packet) *Subnet {
+	if address, ok := p.Options.Uint32(packet.OptionSubnetSelection); ok {
+		return s.subnetOf(address)
+	}
+	if p.Relayed() {
+		return s.subnetOf(p.GatewayAddress)
+	}
+	if p.Interface != 0 && p.Interface != s.device.Index {
+		return s.subnetOf(p.ClientAddress)
+	}
+
+	for _, ipnet := range interfaceNetworks(s.device) {
+		if subnet := s.subnetOf(binary.BigEndian.Uint32(ipnet.IP)); subnet != nil {
+			return subnet
+		}
+	}
+	return nil
+}
+
+// Returns the IPv4 networks configured on an interface.
+func interfaceNetworks(device *net.Interface) []*net.IPNet {
+	addrs, err := device.Addrs()
+	if err != nil {
+		return nil
+	}
+	var networks []*net.IPNet
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		networks = append(networks, &net.IPNet{
+			IP:   ipnet.IP.To4(),
+			Mask: ipnet.Mask[len(ipnet.Mask)-4:],
+		})
+	}
+	return networks
+}
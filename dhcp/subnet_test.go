@@ -0,0 +1,33 @@
+package dhcp
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPrepareSubnetsInterfaceOverlap(t *testing.T) {
+	local := &net.IPNet{IP: net.IPv4(192, 168, 0, 1).To4(), Mask: net.CIDRMask(24, 32)}
+	tests := []struct {
+		network string
+		err     string
+	}{
+		{"10.0.5.0/24", ""},
+		{"192.168.0.0/24", "subnet 192.168.0.0/24 of the interface overlaps configured subnet 192.168.0.0/24"},
+		{"192.168.0.128/25", "subnet 192.168.0.0/24 of the interface overlaps configured subnet 192.168.0.128/25"},
+		{"192.168.0.0/16", "subnet 192.168.0.0/24 of the interface overlaps configured subnet 192.168.0.0/16"},
+	}
+	for _, tt := range tests {
+		implicit, _ := NewSubnet("", &SubnetOptions{}, nil)
+		configured, err := NewSubnet(tt.network, &SubnetOptions{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = prepareSubnets([]*Subnet{configured, implicit}, local)
+		if tt.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.network, err)
+		}
+		if tt.err != "" && (err == nil || err.Error() != tt.err) {
+			t.Errorf("%s: error = %v, want %q", tt.network, err, tt.err)
+		}
+	}
+}
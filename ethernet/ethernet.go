@@ -21,7 +21,8 @@ func SendEthernet(payload []byte, addr *addresses.Addresses, udpinfo *udp.Header
 	}, udpPacket)
 
 	// Destination and Source MAC
-	ethernetPacket := bytes.NewBuffer(targetMAC)
+	ethernetPacket := new(bytes.Buffer)
+	ethernetPacket.Write(targetMAC)
 	ethernetPacket.Write(device.HardwareAddr)
 
 	// EtherType, here: IPv4
@@ -0,0 +1,59 @@
+package lease
+
+import (
+	"fmt"
+	"time"
+)
+
+// State of a lease.
+type State uint8
+
+const (
+	// Address was offered but not yet requested.
+	Offered State = iota + 1
+	// Address was acknowledged to the client.
+	Bound
+	// Client gave the address back.
+	Released
+	// Lease ran out, the address is held for the grace period.
+	Expired
+	// Client reported the address as already in use.
+	Declined
+)
+
+func (s State) String() string {
+	switch s {
+	case Offered:
+		return "offered"
+	case Bound:
+		return "bound"
+	case Released:
+		return "released"
+	case Expired:
+		return "expired"
+	case Declined:
+		return "declined"
+	}
+	return fmt.Sprintf("state(%d)", uint8(s))
+}
+
+// A lease of an address to a client.
+type Lease struct {
+	// Client's MAC address as a hex string.
+	MAC string `json:"mac"`
+	// Client identifier (option 61) as a hex string, if the client sent one.
+	ClientID string `json:"client_id,omitempty"`
+
+	Address uint32    `json:"address"`
+	Start   time.Time `json:"start"`
+	Expiry  time.Time `json:"expiry"`
+	State   State     `json:"state"`
+}
+
+// Reports whether the lease still belongs to its client.
+//
+// Expired leases count as active until they are reclaimed, so a client
+// that comes back during the grace period keeps its address.
+func (l *Lease) Active() bool {
+	return l.State == Offered || l.State == Bound || l.State == Expired
+}
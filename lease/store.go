@@ -0,0 +1,347 @@
+package lease
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Journal grows to at least this many records before it gets compacted.
+const compactThreshold = 1024
+
+// Operations recorded in the journal.
+const (
+	opPut    = "put"
+	opDelete = "delete"
+)
+
+// A single journal entry.
+type record struct {
+	Op      string `json:"op"`
+	Lease   *Lease `json:"lease,omitempty"`
+	Address uint32 `json:"address,omitempty"`
+}
+
+// Durable lease database.
+//
+// Every change is appended to a journal file and synced before it is applied,
+// the journal is replayed when the store is opened and compacted into a
+// snapshot of the live leases once it grows too large.
+//
+// Each journal line carries a checksum, a torn line left behind by a crash is
+// dropped on the next start.
+type Store struct {
+	mu sync.Mutex
+
+	path    string
+	file    *os.File
+	leases  map[uint32]Lease
+	records int
+}
+
+// Opens the lease database at path, creating it if it doesn't exist.
+func Open(path string) (*Store, error) {
+	s := &Store{
+		path:   path,
+		leases: make(map[uint32]Lease),
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = s.replay(file)
+	file.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	// Start with a fresh journal holding only the live leases.
+	if err := s.compact(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Replays the journal, cutting off anything after the last valid record.
+func (s *Store) replay(file *os.File) error {
+	reader := bufio.NewReader(file)
+	var offset int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) > 0 {
+				log.Printf("Lease journal: dropping incomplete record at offset %d, %d bytes lost", offset, len(line))
+			}
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		r, err := decodeRecord(line)
+		if err != nil {
+			// Nothing after a corrupt record can be trusted.
+			rest, readErr := io.ReadAll(reader)
+			if readErr != nil {
+				return readErr
+			}
+			records := 1 + bytes.Count(rest, []byte{'\n'})
+			if len(rest) > 0 && rest[len(rest)-1] != '\n' {
+				records++
+			}
+			log.Printf("Lease journal: dropping corrupt record at offset %d (%v), %d records and %d bytes lost",
+				offset, err, records, len(line)+len(rest))
+			break
+		}
+		s.apply(r)
+		offset += int64(len(line))
+	}
+
+	return file.Truncate(offset)
+}
+
+// Applies a record to the in-memory state.
+func (s *Store) apply(r *record) {
+	switch r.Op {
+	case opPut:
+		s.leases[r.Lease.Address] = *r.Lease
+	case opDelete:
+		delete(s.leases, r.Address)
+	}
+}
+
+// Encodes a record as a journal line: checksum, space, JSON, newline.
+func encodeRecord(r *record) ([]byte, error) {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	line := fmt.Appendf(nil, "%08x ", crc32.ChecksumIEEE(data))
+	line = append(line, data...)
+	return append(line, '\n'), nil
+}
+
+// Decodes and verifies a journal line.
+func decodeRecord(line []byte) (*record, error) {
+	line = bytes.TrimSuffix(line, []byte{'\n'})
+	sum, data, ok := bytes.Cut(line, []byte{' '})
+	if !ok {
+		return nil, errors.New("missing checksum")
+	}
+	if string(sum) != fmt.Sprintf("%08x", crc32.ChecksumIEEE(data)) {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	r := &record{}
+	if err := json.Unmarshal(data, r); err != nil {
+		return nil, err
+	}
+	switch {
+	case r.Op == opPut && r.Lease != nil:
+	case r.Op == opDelete:
+	default:
+		return nil, fmt.Errorf("invalid record %q", r.Op)
+	}
+	return r, nil
+}
+
+// Appends a record to the journal and applies it once it is on disk.
+func (s *Store) append(r *record) error {
+	line, err := encodeRecord(r)
+	if err != nil {
+		return err
+	}
+	if _, err := s.file.Write(line); err != nil {
+		return err
+	}
+	if err := s.file.Sync(); err != nil {
+		return err
+	}
+	s.apply(r)
+
+	s.records++
+	if s.records > compactThreshold && s.records > 2*len(s.leases) {
+		return s.compact()
+	}
+	return nil
+}
+
+// Stores a lease, replacing any lease of the same address.
+func (s *Store) Put(l Lease) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.append(&record{Op: opPut, Lease: &l})
+}
+
+// Deletes the lease of an address.
+func (s *Store) Delete(address uint32) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.leases[address]; !ok {
+		return nil
+	}
+	return s.append(&record{Op: opDelete, Address: address})
+}
+
+// Returns the lease of an address.
+func (s *Store) Get(address uint32) (Lease, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l, ok := s.leases[address]
+	return l, ok
+}
+
+// Returns the most recently started lease matching a condition.
+func (s *Store) Find(match func(l *Lease) bool) (Lease, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var found Lease
+	var ok bool
+	for _, l := range s.leases {
+		if match(&l) && (!ok || l.Start.After(found.Start)) {
+			found, ok = l, true
+		}
+	}
+	return found, ok
+}
+
+// Returns all leases sorted by address.
+func (s *Store) All() []Lease {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sorted()
+}
+
+func (s *Store) sorted() []Lease {
+	leases := make([]Lease, 0, len(s.leases))
+	for _, l := range s.leases {
+		leases = append(leases, l)
+	}
+	sort.Slice(leases, func(i, j int) bool {
+		return leases[i].Address < leases[j].Address
+	})
+	return leases
+}
+
+// Rewrites the journal so it only holds the live leases.
+func (s *Store) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.compact()
+}
+
+func (s *Store) compact() error {
+	// Write the snapshot next to the journal and swap it in atomically.
+	tmp := s.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(file)
+	for _, l := range s.sorted() {
+		line, err := encodeRecord(&record{Op: opPut, Lease: &l})
+		if err != nil {
+			file.Close()
+			return err
+		}
+		writer.Write(line)
+	}
+	if err := writer.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return err
+	}
+	syncDir(filepath.Dir(s.path))
+
+	// Reopen the new journal for appending.
+	if s.file != nil {
+		s.file.Close()
+	}
+	s.file, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	s.records = len(s.leases)
+	return nil
+}
+
+// Syncs a directory so a rename in it is durable.
+func syncDir(path string) {
+	dir, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	dir.Sync()
+	dir.Close()
+}
+
+// Closes the journal.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
+
+// Advances leases through their lifecycle.
+//
+// Bound leases past their expiry become expired and are reclaimed once the
+// grace period is over. Offered leases are reclaimed when the offer runs out,
+// released leases right away and declined leases after their probation.
+//
+// Returns the reclaimed leases, their addresses can be handed out again.
+func (s *Store) Expire(now time.Time, grace time.Duration) ([]Lease, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var reclaimed []Lease
+	for _, l := range s.sorted() {
+		reclaim := false
+		switch l.State {
+		case Offered:
+			reclaim = now.After(l.Expiry)
+		case Bound, Expired:
+			reclaim = now.After(l.Expiry.Add(grace))
+		case Released:
+			reclaim = true
+		case Declined:
+			reclaim = now.After(l.Expiry)
+		}
+
+		if reclaim {
+			if err := s.append(&record{Op: opDelete, Address: l.Address}); err != nil {
+				return reclaimed, err
+			}
+			reclaimed = append(reclaimed, l)
+			continue
+		}
+
+		if l.State == Bound && now.After(l.Expiry) {
+			l.State = Expired
+			if err := s.append(&record{Op: opPut, Lease: &l}); err != nil {
+				return reclaimed, err
+			}
+		}
+	}
+	return reclaimed, nil
+}
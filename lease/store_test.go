@@ -0,0 +1,272 @@
+package lease
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Opens a store in a fresh directory.
+func openTestStore(t *testing.T) (*Store, string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "pisa.leases")
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, path
+}
+
+// Closes and reopens a store, replaying its journal.
+func reopen(t *testing.T, s *Store, path string) *Store {
+	t.Helper()
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	s, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func testLease(address uint32) Lease {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	return Lease{
+		MAC:     "001122334455",
+		Address: address,
+		Start:   start,
+		Expiry:  start.Add(time.Hour),
+		State:   Bound,
+	}
+}
+
+// Returns the addresses of all leases.
+func addresses(s *Store) []uint32 {
+	var list []uint32
+	for _, l := range s.All() {
+		list = append(list, l.Address)
+	}
+	return list
+}
+
+func equalAddresses(a []uint32, b []uint32) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReplayPutDelete(t *testing.T) {
+	s, path := openTestStore(t)
+	for _, address := range []uint32{1, 2, 3} {
+		if err := s.Put(testLease(address)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	updated := testLease(2)
+	updated.State = Released
+	if err := s.Put(updated); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(3); err != nil {
+		t.Fatal(err)
+	}
+
+	s = reopen(t, s, path)
+	if got := addresses(s); !equalAddresses(got, []uint32{1, 2}) {
+		t.Fatalf("leases after replay = %v, want [1 2]", got)
+	}
+	if l, _ := s.Get(2); l.State != Released || !l.Expiry.Equal(updated.Expiry) || l.MAC != updated.MAC {
+		t.Errorf("lease 2 after replay = %+v", l)
+	}
+}
+
+func TestReplayTornRecord(t *testing.T) {
+	s, path := openTestStore(t)
+	s.Put(testLease(1))
+	s.Put(testLease(2))
+	s.Close()
+
+	// A crash in the middle of a write leaves half a line behind.
+	line, err := encodeRecord(&record{Op: opPut, Lease: &Lease{Address: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Write(line[:len(line)/2])
+	file.Close()
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := addresses(s); !equalAddresses(got, []uint32{1, 2}) {
+		t.Fatalf("leases after torn record = %v, want [1 2]", got)
+	}
+
+	// Records written after the torn one survive the next start.
+	if err := s.Put(testLease(4)); err != nil {
+		t.Fatal(err)
+	}
+	s = reopen(t, s, path)
+	if got := addresses(s); !equalAddresses(got, []uint32{1, 2, 4}) {
+		t.Errorf("leases after restart = %v, want [1 2 4]", got)
+	}
+}
+
+func TestReplayChecksumMismatch(t *testing.T) {
+	s, path := openTestStore(t)
+	for _, address := range []uint32{1, 2, 3} {
+		s.Put(testLease(address))
+	}
+	s.Close()
+
+	// Flip a byte inside the second record, everything from there on is dropped.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte{'\n'})
+	lines[1][len(lines[1])-3] ^= 1
+	if err := os.WriteFile(path, bytes.Join(lines, nil), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	if got := addresses(s); !equalAddresses(got, []uint32{1}) {
+		t.Errorf("leases after corrupt record = %v, want [1]", got)
+	}
+	if _, err := decodeRecord(lines[1]); err == nil || err.Error() != "checksum mismatch" {
+		t.Errorf("decoding the corrupt record: %v", err)
+	}
+}
+
+func TestCompaction(t *testing.T) {
+	s, path := openTestStore(t)
+	for i := range 10 {
+		s.Put(testLease(uint32(i % 3)))
+	}
+	s.Delete(0)
+	if err := s.Compact(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The journal only holds the live leases.
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte{'\n'}); n != 2 {
+		t.Errorf("journal holds %d records after compaction, want 2", n)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("snapshot file left behind: %v", err)
+	}
+
+	// Appending after compaction goes to the new journal.
+	s.Put(testLease(7))
+	s = reopen(t, s, path)
+	if got := addresses(s); !equalAddresses(got, []uint32{1, 2, 7}) {
+		t.Errorf("leases after compaction = %v, want [1 2 7]", got)
+	}
+}
+
+func TestExpire(t *testing.T) {
+	s, path := openTestStore(t)
+	base := testLease(0).Expiry
+	grace := 10 * time.Minute
+
+	leases := []struct {
+		address uint32
+		state   State
+	}{
+		{1, Offered},
+		{2, Bound},
+		{3, Released},
+		{4, Declined},
+	}
+	for _, l := range leases {
+		lease := testLease(l.address)
+		lease.State = l.state
+		if err := s.Put(lease); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		name      string
+		now       time.Time
+		reclaimed []uint32
+		states    map[uint32]State
+	}{
+		{
+			name:      "before expiry",
+			now:       base.Add(-time.Minute),
+			reclaimed: []uint32{3},
+			states:    map[uint32]State{1: Offered, 2: Bound, 4: Declined},
+		},
+		{
+			name:      "after expiry",
+			now:       base.Add(time.Minute),
+			reclaimed: []uint32{1, 4},
+			states:    map[uint32]State{2: Expired},
+		},
+		{
+			name:   "end of the grace period",
+			now:    base.Add(grace),
+			states: map[uint32]State{2: Expired},
+		},
+		{
+			name:      "after the grace period",
+			now:       base.Add(grace + time.Second),
+			reclaimed: []uint32{2},
+			states:    map[uint32]State{},
+		},
+	}
+
+	for i, step := range steps {
+		reclaimed, err := s.Expire(step.now, grace)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		var addrs []uint32
+		for _, l := range reclaimed {
+			addrs = append(addrs, l.Address)
+		}
+		if !equalAddresses(addrs, step.reclaimed) {
+			t.Errorf("%s: reclaimed %v, want %v", step.name, addrs, step.reclaimed)
+		}
+		for _, l := range s.All() {
+			if want, ok := step.states[l.Address]; !ok || l.State != want {
+				t.Errorf("%s: lease %d is %s, want %s", step.name, l.Address, l.State, want)
+			}
+		}
+		if len(s.All()) != len(step.states) {
+			t.Errorf("%s: %d leases left, want %d", step.name, len(s.All()), len(step.states))
+		}
+
+		// The expired state is on disk, not only in memory.
+		if i == 1 {
+			s = reopen(t, s, path)
+			if l, _ := s.Get(2); l.State != Expired {
+				t.Errorf("lease 2 is %s after reopening, want expired", l.State)
+			}
+		}
+	}
+}
@@ -1,141 +1,112 @@
 package main
 
 import (
-	"bufio"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"pisa/config"
 	"pisa/dhcp"
-	"pisa/packet"
 	"pisa/util"
-	"regexp"
 	"strconv"
-	"strings"
 )
 
 func main() {
-	var rangeFirst uint32
-	var rangeLast uint32
-	var availableOptions []string
-
-	var dhcpOptions dhcp.DHCPOptions
-
-	addressRegex := regexp.MustCompile(`\d{1,3}.\d{1,3}.\d{1,3}.\d{1,3}-\d{1,3}.\d{1,3}.\d{1,3}.\d{1,3}`)
-	//rangeRegex := regexp.MustCompile(`.-.`)
+	configPath := flag.String("config", config.DefaultPath, "configuration file")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "Usage: pisa [-config file] [check-config | reload]")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
 
 	// Load config
-	configFile, err := os.Open("config.txt")
-	util.OnError(err)
-
-	scanner := bufio.NewScanner(configFile)
-	for scanner.Scan() {
-		line := scanner.Text()
-		entry := strings.Split(line, "=")
-		if len(entry) > 1 {
-			switch entry[0] {
-			case "addresses":
-				m := addressRegex.MatchString(entry[1])
-				if !m {
-					panic(fmt.Errorf("invalid address entry: " + line))
-				}
-
-				// Split the string into highest and lowest address
-				addrs := strings.Split(entry[1], "-")
-				rangeFirst = util.AddressIntoUint32(addrs[0])
-				rangeLast = util.AddressIntoUint32(addrs[1])
-
-			// interface
-			case "interface":
-				availableOptions = append(availableOptions, entry[0])
-				dhcpOptions.Interface = entry[1]
-
-			// Router
-			case "router":
-				addressSlice := strings.Split(entry[1], ",")
-				if len(addressSlice) > 1 {
-					for i := 0; i <= len(addressSlice); i++ {
-						if !util.CheckAddress(addressSlice[i]) {
-							log.Panic("Invalid address entry: " + addressSlice[i])
-						}
-					}
-				}
-				dhcpOptions.Router = addressSlice
-				availableOptions = append(availableOptions, entry[0])
-
-			// Subnet Mask
-			case "subnetmask":
-				addressSlice := strings.Split(entry[1], ",")
-				if len(addressSlice) > 1 {
-					for i := 0; i <= len(addressSlice); i++ {
-						if !util.CheckAddress(addressSlice[i]) {
-							log.Panic("Invalid address entry: " + addressSlice[i])
-						}
-					}
-				}
-				dhcpOptions.SubnetMask = entry[1]
-				availableOptions = append(availableOptions, entry[0])
-
-			// Time server
-			case "timesvr":
-				addressSlice := strings.Split(entry[1], ",")
-				if len(addressSlice) > 1 {
-					for i := 0; i <= len(addressSlice); i++ {
-						if !util.CheckAddress(addressSlice[i]) {
-							log.Panic("Invalid address entry: " + addressSlice[i])
-						}
-					}
-				}
-				dhcpOptions.TimeServer = addressSlice
-				availableOptions = append(availableOptions, entry[0])
-
-			// Domain Name server
-			case "dns":
-				addressSlice := strings.Split(entry[1], ",")
-				if len(addressSlice) > 1 {
-					for i := 0; i <= len(addressSlice); i++ {
-						if !util.CheckAddress(addressSlice[i]) {
-							log.Panic("Invalid address entry: " + addressSlice[i])
-						}
-					}
-				}
-				dhcpOptions.DNS = addressSlice
-				availableOptions = append(availableOptions, entry[0])
-
-			// Lease time
-			case "lease":
-				time, err := strconv.ParseUint(entry[1], 10, 0)
-				util.OnError(err)
-				dhcpOptions.Lease = uint(time)
-				availableOptions = append(availableOptions, entry[0])
-			default:
-				// Panics if a setting is unknown.
-				panic(fmt.Errorf("unknown setting: " + line))
-			}
-
-		} else {
-			// Panics if a configuration entry isn't in the format of:
-			// key=value
-			panic(fmt.Errorf("invalid configuration entry: " + line))
+	cfg, err := config.Load(*configPath)
+
+	switch flag.Arg(0) {
+	// Runs the server or relay agent.
+	case "":
+	// Validates the configuration without starting anything.
+	case "check-config":
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(*configPath, "is valid")
+		return
+	// Makes the running server pick up the configuration.
+	case "reload":
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
 		}
+		pid, err := sendReload(cfg.Options.PidFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println("Asked pisa (pid", strconv.Itoa(pid)+") to reload", *configPath)
+		return
+	default:
+		flag.Usage()
+		os.Exit(2)
 	}
-	// Panics if no interface was provided
-	if dhcpOptions.Interface == "" {
-		panic(fmt.Errorf("no interface provided"))
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
 
 	// If all went well, logs that the configuration was accepted.
 	log.Println("Loaded the configuration!")
 
+	util.OnError(checkPidFile(cfg.Options.PidFile))
+
+	if cfg.Options.Mode == dhcp.ModeRelay {
+		runRelay(&cfg.Options)
+		return
+	}
+
 	// Starts the server.
-	Server := dhcp.StartServer(&dhcpOptions, rangeFirst, rangeLast, availableOptions)
+	Server := dhcp.StartServer(&cfg.Options, cfg.Subnets, cfg.Reservations)
 	defer Server.SrvConn.Close()
+	defer Server.Leases.Close()
+	signals := notifySignals()
+	util.OnError(writePidFile(cfg.Options.PidFile))
+
+	// Picks up configuration changes without losing leases.
+	go handleSignals(signals, cfg.Options.PidFile, func() {
+		newCfg, err := config.Load(*configPath)
+		if err != nil {
+			log.Println("Reload failed, keeping the old configuration:\n" + err.Error())
+			return
+		}
+		err = Server.Reload(&newCfg.Options, newCfg.Subnets, newCfg.Reservations)
+		if err != nil {
+			log.Println("Reload failed, keeping the old configuration:", err)
+			return
+		}
+		log.Println("Reloaded the configuration!")
+	})
 
 	// Reading from UDP.
 	for {
-		data, err := Server.Read()
-		if len(data) > 0 {
-			packet := packet.FromBytes(data)
+		datagram, err := Server.Read()
+		util.OnError(err)
+		if len(datagram.Data) > 0 {
+			packet, err := datagram.Packet()
+			if err != nil {
+				// Malformed packets are dropped instead of taking the server down.
+				log.Println("Dropping malformed packet:", err)
+				continue
+			}
+			if !Server.Serves(packet) {
+				// A client on another interface, the server doesn't answer it.
+				continue
+			}
 			fmt.Println(packet.DHCPAction)
+			if packet.Relayed() {
+				log.Println("Packet from", packet.StringMAC, "relayed by", util.Uint32IntoAddress(packet.GatewayAddress))
+			}
 			// Client sends DHCP discover
 			switch packet.DHCPAction {
 			case 1:
@@ -144,11 +115,50 @@ func main() {
 				util.NonFatalError(err)
 			// Client sends DHCP request
 			case 3:
-				log.Println("Received DHCPREQUEST from ", packet.StringMAC, ".Sending DHCPOFFER")
-				err := Server.SendDHCPAck(packet)
+				err := Server.HandleRequest(packet)
+				util.NonFatalError(err)
+			// Client found the address in use
+			case 4:
+				log.Println("Received DHCPDECLINE from ", packet.StringMAC)
+				err := Server.Decline(packet)
+				util.NonFatalError(err)
+			// Client gives up its address
+			case 7:
+				log.Println("Received DHCPRELEASE from ", packet.StringMAC)
+				err := Server.Release(packet)
+				util.NonFatalError(err)
+			// Statically configured host asks for options
+			case 8:
+				log.Println("Received DHCPINFORM from ", packet.StringMAC)
+				err := Server.HandleInform(packet)
 				util.NonFatalError(err)
 			}
 		}
+	}
+}
+
+// Runs pisa as a relay agent between clients and the upstream servers.
+func runRelay(dhcpOptions *dhcp.DHCPOptions) {
+	relay := dhcp.StartRelay(dhcpOptions)
+	defer relay.Conn.Close()
+	signals := notifySignals()
+	util.OnError(writePidFile(dhcpOptions.PidFile))
+
+	go handleSignals(signals, dhcpOptions.PidFile, func() {
+		log.Println("The relay agent can't reload its configuration, restart pisa instead")
+	})
+
+	for {
+		datagram, err := relay.Read()
 		util.OnError(err)
+		if len(datagram.Data) > 0 {
+			packet, err := datagram.Packet()
+			if err != nil {
+				log.Println("Dropping malformed packet:", err)
+				continue
+			}
+			err = relay.Relay(packet)
+			util.NonFatalError(err)
+		}
 	}
 }
@@ -0,0 +1,263 @@
+package packet
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// DHCP option codes used by pisa.
+const (
+	OptionPad                  uint8 = 0
+	OptionSubnetMask           uint8 = 1
+	OptionRouter               uint8 = 3
+	OptionTimeServer           uint8 = 4
+	OptionDNS                  uint8 = 6
+	OptionHostname             uint8 = 12
+	OptionRequestedAddress     uint8 = 50
+	OptionLeaseTime            uint8 = 51
+	OptionOverload             uint8 = 52
+	OptionMessageType          uint8 = 53
+	OptionServerIdentifier     uint8 = 54
+	OptionParameterRequestList uint8 = 55
+	OptionMessage              uint8 = 56
+	OptionMaxMessageSize       uint8 = 57
+	OptionRenewalTime          uint8 = 58
+	OptionRebindingTime        uint8 = 59
+	OptionClientIdentifier     uint8 = 61
+	OptionSubnetSelection      uint8 = 118
+	OptionEnd                  uint8 = 255
+)
+
+// DHCP message types (option 53).
+const (
+	DHCPDiscover uint8 = 1
+	DHCPOffer    uint8 = 2
+	DHCPRequest  uint8 = 3
+	DHCPDecline  uint8 = 4
+	DHCPAck      uint8 = 5
+	DHCPNak      uint8 = 6
+	DHCPRelease  uint8 = 7
+	DHCPInform   uint8 = 8
+)
+
+// A single DHCP option.
+type Option struct {
+	Code uint8
+	Data []byte
+}
+
+// Ordered collection of DHCP options.
+//
+// Every code is present at most once, options that were split into
+// several instances on the wire are joined together (RFC 3396).
+type Options []Option
+
+// Decodes the options field (without the magic cookie).
+//
+// Pad options are skipped and decoding stops at the End option.
+// Returns the options decoded so far and an error if the data is truncated.
+func DecodeOptions(data []byte) (Options, error) {
+	var options Options
+	for i := 0; i < len(data); {
+		code := data[i]
+		if code == OptionPad {
+			i++
+			continue
+		}
+		if code == OptionEnd {
+			return options, nil
+		}
+
+		if i+1 >= len(data) {
+			return options, fmt.Errorf("option %d: missing length", code)
+		}
+		length := int(data[i+1])
+		if i+2+length > len(data) {
+			return options, fmt.Errorf("option %d: length %d exceeds remaining %d bytes", code, length, len(data)-i-2)
+		}
+
+		// Multiple instances of the same option are concatenated.
+		options.Append(code, data[i+2:i+2+length])
+		i += 2 + length
+	}
+	return options, nil
+}
+
+// Encodes the options terminated by the End option.
+//
+// Options longer than 255 bytes are split into several instances (RFC 3396).
+func (o Options) Encode() []byte {
+	var b []byte
+	for _, opt := range o {
+		b = append(b, encodeOption(opt)...)
+	}
+	return append(b, OptionEnd)
+}
+
+// Encodes a single option, splitting it if needed.
+func encodeOption(opt Option) []byte {
+	if len(opt.Data) == 0 {
+		return []byte{opt.Code, 0}
+	}
+
+	var b []byte
+	for data := opt.Data; len(data) > 0; {
+		n := min(len(data), 255)
+		b = append(b, opt.Code, byte(n))
+		b = append(b, data[:n]...)
+		data = data[n:]
+	}
+	return b
+}
+
+// Returns the index of an option or -1.
+func (o Options) index(code uint8) int {
+	for i := range o {
+		if o[i].Code == code {
+			return i
+		}
+	}
+	return -1
+}
+
+// Returns the raw data of an option.
+func (o Options) Get(code uint8) ([]byte, bool) {
+	i := o.index(code)
+	if i < 0 {
+		return nil, false
+	}
+	return o[i].Data, true
+}
+
+// Reports whether an option is present.
+func (o Options) Has(code uint8) bool {
+	return o.index(code) >= 0
+}
+
+// Sets an option, replacing an existing one in place.
+func (o *Options) Set(code uint8, data []byte) {
+	if i := o.index(code); i >= 0 {
+		(*o)[i].Data = data
+		return
+	}
+	*o = append(*o, Option{Code: code, Data: data})
+}
+
+// Appends data to an option, creating it if it does not exist.
+func (o *Options) Append(code uint8, data []byte) {
+	if i := o.index(code); i >= 0 {
+		joined := make([]byte, 0, len((*o)[i].Data)+len(data))
+		joined = append(joined, (*o)[i].Data...)
+		(*o)[i].Data = append(joined, data...)
+		return
+	}
+	*o = append(*o, Option{Code: code, Data: append([]byte(nil), data...)})
+}
+
+// Removes an option.
+func (o *Options) Delete(code uint8) {
+	if i := o.index(code); i >= 0 {
+		*o = append((*o)[:i], (*o)[i+1:]...)
+	}
+}
+
+// Returns a copy that can be modified without touching the original.
+func (o Options) Copy() Options {
+	c := make(Options, len(o))
+	for i, opt := range o {
+		c[i] = Option{Code: opt.Code, Data: append([]byte(nil), opt.Data...)}
+	}
+	return c
+}
+
+// Returns an option as a uint8.
+func (o Options) Uint8(code uint8) (uint8, bool) {
+	data, ok := o.Get(code)
+	if !ok || len(data) != 1 {
+		return 0, false
+	}
+	return data[0], true
+}
+
+// Returns an option as a uint16.
+func (o Options) Uint16(code uint8) (uint16, bool) {
+	data, ok := o.Get(code)
+	if !ok || len(data) != 2 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint16(data), true
+}
+
+// Returns an option as a uint32.
+func (o Options) Uint32(code uint8) (uint32, bool) {
+	data, ok := o.Get(code)
+	if !ok || len(data) != 4 {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(data), true
+}
+
+// Returns an option holding a single address.
+func (o Options) IP(code uint8) ([]byte, bool) {
+	data, ok := o.Get(code)
+	if !ok || len(data) != 4 {
+		return nil, false
+	}
+	return data, true
+}
+
+// Returns an option holding a list of addresses.
+func (o Options) IPs(code uint8) ([][]byte, bool) {
+	data, ok := o.Get(code)
+	if !ok || len(data) == 0 || len(data)%4 != 0 {
+		return nil, false
+	}
+	var addrs [][]byte
+	for i := 0; i < len(data); i += 4 {
+		addrs = append(addrs, data[i:i+4])
+	}
+	return addrs, true
+}
+
+// Returns an option as a string.
+func (o Options) String(code uint8) (string, bool) {
+	data, ok := o.Get(code)
+	if !ok {
+		return "", false
+	}
+	return string(data), true
+}
+
+// Sets an option to a uint8.
+func (o *Options) SetUint8(code uint8, v uint8) {
+	o.Set(code, []byte{v})
+}
+
+// Sets an option to a uint16.
+func (o *Options) SetUint16(code uint8, v uint16) {
+	o.Set(code, binary.BigEndian.AppendUint16(nil, v))
+}
+
+// Sets an option to a uint32.
+func (o *Options) SetUint32(code uint8, v uint32) {
+	o.Set(code, binary.BigEndian.AppendUint32(nil, v))
+}
+
+// Sets an option to a single address.
+func (o *Options) SetIP(code uint8, addr []byte) {
+	o.Set(code, append([]byte(nil), addr[:4]...))
+}
+
+// Sets an option to a list of addresses.
+func (o *Options) SetIPs(code uint8, addrs [][]byte) {
+	data := make([]byte, 0, len(addrs)*4)
+	for _, addr := range addrs {
+		data = append(data, addr[:4]...)
+	}
+	o.Set(code, data)
+}
+
+// Sets an option to a string.
+func (o *Options) SetString(code uint8, v string) {
+	o.Set(code, []byte(v))
+}
@@ -0,0 +1,154 @@
+package packet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Options
+		err  string
+	}{
+		{
+			name: "empty",
+			data: nil,
+		},
+		{
+			name: "pad and end",
+			data: []byte{0, 0, 53, 1, 5, 0, 255, 12, 2, 'n', 'o'},
+			want: Options{{Code: 53, Data: []byte{5}}},
+		},
+		{
+			name: "no end",
+			data: []byte{53, 1, 1, 1, 4, 255, 255, 255, 0},
+			want: Options{{Code: 53, Data: []byte{1}}, {Code: 1, Data: []byte{255, 255, 255, 0}}},
+		},
+		{
+			name: "zero length",
+			data: []byte{80, 0, 255},
+			want: Options{{Code: 80, Data: []byte{}}},
+		},
+		{
+			name: "repeated instances are joined",
+			data: []byte{6, 4, 1, 1, 1, 1, 53, 1, 3, 6, 4, 8, 8, 8, 8, 255},
+			want: Options{{Code: 6, Data: []byte{1, 1, 1, 1, 8, 8, 8, 8}}, {Code: 53, Data: []byte{3}}},
+		},
+		{
+			name: "missing length",
+			data: []byte{53, 1, 1, 12},
+			want: Options{{Code: 53, Data: []byte{1}}},
+			err:  "option 12: missing length",
+		},
+		{
+			name: "length beyond data",
+			data: []byte{53, 1, 1, 12, 5, 'a', 'b'},
+			want: Options{{Code: 53, Data: []byte{1}}},
+			err:  "option 12: length 5 exceeds remaining 2 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := DecodeOptions(tt.data)
+			if tt.err == "" && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+			if !equalOptions(got, tt.want) {
+				t.Errorf("options = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEncodeSplitsLongOptions(t *testing.T) {
+	long := bytes.Repeat([]byte{'x'}, 600)
+	options := Options{{Code: 53, Data: []byte{2}}, {Code: 119, Data: long}, {Code: 80}}
+	encoded := options.Encode()
+
+	// 600 bytes take three instances: 255, 255 and 90.
+	want := 3 + (2 + 255) + (2 + 255) + (2 + 90) + 2 + 1
+	if len(encoded) != want {
+		t.Fatalf("encoded length = %d, want %d", len(encoded), want)
+	}
+	if encoded[3] != 119 || encoded[4] != 255 || encoded[3+257] != 119 || encoded[3+257+1] != 255 ||
+		encoded[3+514] != 119 || encoded[3+514+1] != 90 {
+		t.Errorf("option 119 not split into 255, 255 and 90 bytes")
+	}
+	if encoded[len(encoded)-1] != OptionEnd {
+		t.Errorf("missing End option")
+	}
+
+	decoded, err := DecodeOptions(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equalOptions(decoded, options) {
+		t.Errorf("round trip = %v, want %v", decoded, options)
+	}
+}
+
+func TestOptionsAccessors(t *testing.T) {
+	var o Options
+	o.SetUint8(OptionMessageType, DHCPAck)
+	o.SetUint16(OptionMaxMessageSize, 1500)
+	o.SetUint32(OptionLeaseTime, 3600)
+	o.SetIP(OptionSubnetMask, []byte{255, 255, 255, 0})
+	o.SetIPs(OptionDNS, [][]byte{{1, 1, 1, 1}, {8, 8, 8, 8}})
+	o.SetString(OptionHostname, "host")
+
+	if v, ok := o.Uint8(OptionMessageType); !ok || v != DHCPAck {
+		t.Errorf("Uint8 = %d, %v", v, ok)
+	}
+	if v, ok := o.Uint16(OptionMaxMessageSize); !ok || v != 1500 {
+		t.Errorf("Uint16 = %d, %v", v, ok)
+	}
+	if v, ok := o.Uint32(OptionLeaseTime); !ok || v != 3600 {
+		t.Errorf("Uint32 = %d, %v", v, ok)
+	}
+	if v, ok := o.IP(OptionSubnetMask); !ok || !bytes.Equal(v, []byte{255, 255, 255, 0}) {
+		t.Errorf("IP = %v, %v", v, ok)
+	}
+	if v, ok := o.IPs(OptionDNS); !ok || len(v) != 2 || !bytes.Equal(v[1], []byte{8, 8, 8, 8}) {
+		t.Errorf("IPs = %v, %v", v, ok)
+	}
+	if v, ok := o.String(OptionHostname); !ok || v != "host" {
+		t.Errorf("String = %q, %v", v, ok)
+	}
+
+	// Values of the wrong size aren't returned.
+	if _, ok := o.Uint16(OptionMessageType); ok {
+		t.Errorf("Uint16 accepted a 1 byte option")
+	}
+	if _, ok := o.IP(OptionDNS); ok {
+		t.Errorf("IP accepted a list of addresses")
+	}
+
+	o.Delete(OptionDNS)
+	if o.Has(OptionDNS) {
+		t.Errorf("option still present after Delete")
+	}
+	o.Append(OptionHostname, []byte("name"))
+	if v, _ := o.String(OptionHostname); v != "hostname" {
+		t.Errorf("Append = %q", v)
+	}
+}
+
+// Reports whether two option lists hold the same options in the same order.
+func equalOptions(a Options, b Options) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Code != b[i].Code || !bytes.Equal(a[i].Data, b[i].Data) {
+			return false
+		}
+	}
+	return true
+}
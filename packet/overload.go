@@ -0,0 +1,138 @@
+package packet
+
+import "fmt"
+
+// Values of the Option Overload option (52), the fields holding options.
+const (
+	OverloadFile  uint8 = 1
+	OverloadSname uint8 = 2
+	OverloadBoth  uint8 = 3
+)
+
+// Appends the options held in the file or sname field.
+func (o *Options) decodeOverloaded(field []byte) error {
+	overloaded, err := DecodeOptions(field)
+	if err != nil {
+		return fmt.Errorf("malformed overloaded options: %w", err)
+	}
+	for _, opt := range overloaded {
+		o.Append(opt.Code, opt.Data)
+	}
+	return nil
+}
+
+// A field options are written into.
+type optionField struct {
+	data []byte
+	// Bytes available, including the End option.
+	space int
+}
+
+// Writes an option into the field if it fits whole, reports whether it did.
+//
+// Only data longer than 255 bytes is split into several instances.
+func (f *optionField) writeWhole(opt Option) bool {
+	encoded := encodeOption(opt)
+	if len(encoded) > f.space-len(f.data)-1 {
+		return false
+	}
+	f.data = append(f.data, encoded...)
+	return true
+}
+
+// Writes as much of an option into the field as fits, returns the rest of its data.
+//
+// Options can be split at any point (RFC 3396).
+func (f *optionField) write(code uint8, data []byte) ([]byte, bool) {
+	available := f.space - len(f.data) - 1 - 2
+	if available <= 0 {
+		return data, false
+	}
+	n := min(len(data), available, 255)
+	f.data = append(f.data, code, byte(n))
+	f.data = append(f.data, data[:n]...)
+	return data[n:], true
+}
+
+// Lays the options out for MarshalBinary.
+//
+// Options go into the options field while they fit into MaxSize, the others are moved
+// into the file and then the sname field (RFC 2131 4.1), as long as those are empty.
+// Each option goes whole into the first field with room for it, only options no
+// field can hold are split across them. Relay agent information stays at the end
+// of the options field.
+//
+// Returns the options field and the contents of file and sname.
+func (p *Packet) layoutOptions() ([]byte, []byte, []byte, error) {
+	encoded := p.Options.Encode()
+	if p.MaxSize == 0 || headerLength+4+len(encoded) <= p.MaxSize {
+		return encoded, p.File, p.Hostname, nil
+	}
+
+	rest := p.Options.Copy()
+	var last []byte
+	if data, ok := rest.Get(OptionRelayAgentInfo); ok {
+		last = encodeOption(Option{Code: OptionRelayAgentInfo, Data: data})
+		rest.Delete(OptionRelayAgentInfo)
+	}
+
+	// The options field starts with option 52.
+	fields := []*optionField{{space: p.MaxSize - headerLength - 4 - 3 - len(last)}}
+	file, sname := &optionField{}, &optionField{}
+	if len(p.File) == 0 {
+		file.space = fileLength
+		fields = append(fields, file)
+	}
+	if len(p.Hostname) == 0 {
+		sname.space = snameLength
+		fields = append(fields, sname)
+	}
+
+	for _, opt := range rest {
+		// Clients without RFC 3396 support can't join the pieces of a split option,
+		// so options are only split when no field holds them whole.
+		whole := false
+		for _, field := range fields {
+			if whole = field.writeWhole(opt); whole {
+				break
+			}
+		}
+		if whole {
+			continue
+		}
+
+		// The pieces follow the order options, file, sname they are joined in.
+		data := opt.Data
+		for _, field := range fields {
+			for ok := true; ok && len(data) > 0; {
+				data, ok = field.write(opt.Code, data)
+			}
+		}
+		if len(data) > 0 || len(opt.Data) == 0 {
+			return nil, nil, nil, fmt.Errorf("options don't fit into %d bytes", p.MaxSize)
+		}
+	}
+
+	var overload uint8
+	if len(file.data) > 0 {
+		overload |= OverloadFile
+	}
+	if len(sname.data) > 0 {
+		overload |= OverloadSname
+	}
+
+	options := append([]byte{OptionOverload, 1, overload}, fields[0].data...)
+	options = append(options, last...)
+	options = append(options, OptionEnd)
+	if overload&OverloadFile != 0 {
+		file.data = append(file.data, OptionEnd)
+	} else {
+		file.data = p.File
+	}
+	if overload&OverloadSname != 0 {
+		sname.data = append(sname.data, OptionEnd)
+	} else {
+		sname.data = p.Hostname
+	}
+	return options, file.data, sname.data, nil
+}
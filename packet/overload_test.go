@@ -0,0 +1,217 @@
+package packet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Returns the codes of the options in a field, in the order they appear, including pieces.
+func fieldCodes(t *testing.T, field []byte) []uint8 {
+	t.Helper()
+	var codes []uint8
+	for i := 0; i < len(field) && field[i] != OptionEnd; {
+		if field[i] == OptionPad {
+			i++
+			continue
+		}
+		if i+1 >= len(field) {
+			t.Fatalf("truncated field %v", field)
+		}
+		codes = append(codes, field[i])
+		i += 2 + int(field[i+1])
+	}
+	return codes
+}
+
+// Marshals a reply and parses it back.
+func roundTrip(t *testing.T, reply *Packet) ([]byte, *Packet) {
+	t.Helper()
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > reply.MaxSize {
+		t.Fatalf("%d bytes exceed the maximum of %d", len(data), reply.MaxSize)
+	}
+	p, err := FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data, p
+}
+
+func TestOverloadMovesWholeOptions(t *testing.T) {
+	reply := testReply(DHCPAck)
+	reply.Options = nil
+	reply.Options.SetUint8(OptionMessageType, DHCPAck)
+	reply.Options.Set(43, bytes.Repeat([]byte{'v'}, 250))
+	reply.Options.Set(44, bytes.Repeat([]byte{1}, 44))
+	// Three bytes are left in the options field, the mask must not be split to use them.
+	reply.Options.SetIP(OptionSubnetMask, []byte{255, 255, 255, 0})
+	reply.Options.SetString(OptionHostname, "client")
+	reply.MaxSize = 548
+
+	data, p := roundTrip(t, reply)
+	options := fieldCodes(t, data[240:])
+	if options[0] != OptionOverload || data[242] != OverloadFile {
+		t.Fatalf("options field starts with %v, want option 52 set to file", data[240:243])
+	}
+	if want := []uint8{OptionOverload, OptionMessageType, 43, 44}; !bytes.Equal(options, want) {
+		t.Errorf("options field holds %v, want %v", options, want)
+	}
+	if file := fieldCodes(t, data[108:236]); !bytes.Equal(file, []byte{OptionSubnetMask, OptionHostname}) {
+		t.Errorf("file holds %v, want [1 12]", file)
+	}
+	if !bytes.Equal(data[108:114], []byte{OptionSubnetMask, 4, 255, 255, 255, 0}) {
+		t.Errorf("subnet mask = %v", data[108:114])
+	}
+
+	if !equalOptions(p.Options, reply.Options) {
+		t.Errorf("options = %v, want %v", p.Options, reply.Options)
+	}
+	if p.File != nil || len(p.Hostname) != snameLength || p.MaxSize != 0 {
+		t.Errorf("file %v, sname %d bytes, max size %d", p.File, len(p.Hostname), p.MaxSize)
+	}
+}
+
+func TestOverloadSplitsOnlyWhenNoFieldHoldsAnOption(t *testing.T) {
+	reply := testReply(DHCPOffer)
+	reply.Options.Set(119, bytes.Repeat([]byte{'d'}, 400))
+	reply.Options.SetString(OptionHostname, "client")
+	reply.MaxSize = 576 - 28
+
+	data, p := roundTrip(t, reply)
+	if data[242] != OverloadBoth {
+		t.Errorf("overload = %d, want both", data[242])
+	}
+	// The domain search list is too big for any field, the other options aren't split.
+	options := fieldCodes(t, data[240:])
+	file := fieldCodes(t, data[108:236])
+	sname := fieldCodes(t, data[44:108])
+	count := make(map[uint8]int)
+	for _, code := range append(append(options, file...), sname...) {
+		count[code]++
+	}
+	for code, n := range count {
+		if code != 119 && n > 1 {
+			t.Errorf("option %d split into %d pieces", code, n)
+		}
+	}
+	if count[119] < 2 {
+		t.Errorf("option 119 in %d pieces", count[119])
+	}
+
+	if !equalOptions(p.Options, reply.Options) {
+		t.Errorf("options = %v, want %v", p.Options, reply.Options)
+	}
+	if p.File != nil || p.Hostname != nil {
+		t.Errorf("file and sname still set after reading overloaded fields")
+	}
+}
+
+func TestOverloadKeepsRelayInfoLast(t *testing.T) {
+	reply := testReply(DHCPAck)
+	reply.Options.Set(43, bytes.Repeat([]byte{'v'}, 250))
+	reply.Options.Set(OptionRelayAgentInfo, []byte{SubOptionCircuitID, 2, 'p', '1'})
+	reply.Options.Set(44, bytes.Repeat([]byte{1}, 100))
+	reply.MaxSize = 548
+
+	data, p := roundTrip(t, reply)
+	options := fieldCodes(t, data[240:])
+	if options[len(options)-1] != OptionRelayAgentInfo {
+		t.Errorf("options field holds %v, relay agent information isn't last", options)
+	}
+	if p.RelayInfo == nil || string(p.RelayInfo.CircuitID) != "p1" {
+		t.Errorf("relay agent information = %+v", p.RelayInfo)
+	}
+}
+
+func TestOverloadUsesOnlyEmptyFields(t *testing.T) {
+	reply := testReply(DHCPAck)
+	reply.File = []byte("pxelinux.0")
+	reply.Options.Set(43, bytes.Repeat([]byte{'v'}, 250))
+	reply.Options.Set(44, bytes.Repeat([]byte{1}, 40))
+	reply.MaxSize = 548
+
+	data, p := roundTrip(t, reply)
+	if data[242] != OverloadSname {
+		t.Errorf("overload = %d, want sname", data[242])
+	}
+	if !bytes.HasPrefix(p.File, []byte("pxelinux.0")) {
+		t.Errorf("file = %q", p.File)
+	}
+	if !equalOptions(p.Options, reply.Options) {
+		t.Errorf("options = %v, want %v", p.Options, reply.Options)
+	}
+
+	// Nothing is left for options that fit nowhere.
+	reply.Hostname = []byte("server")
+	reply.Options.Set(45, bytes.Repeat([]byte{1}, 200))
+	if _, err := reply.MarshalBinary(); err == nil || !strings.Contains(err.Error(), "don't fit") {
+		t.Errorf("error = %v, want options that don't fit", err)
+	}
+}
+
+// Returns a request with options overloaded into file and sname.
+func overloadedRequest(overload uint8, file []byte, sname []byte) []byte {
+	data := testDiscover()[:headerLength+4]
+	copy(data[108:236], file)
+	copy(data[44:108], sname)
+	data = append(data, OptionMessageType, 1, DHCPRequest, OptionOverload, 1, overload)
+	data = append(data, 119, 3, 'a', 'b', 'c', OptionEnd)
+	return append(data, make([]byte, 300-len(data))...)
+}
+
+func TestFromBytesReadsOverloadedFields(t *testing.T) {
+	data := overloadedRequest(OverloadBoth,
+		[]byte{119, 2, 'd', 'e', OptionHostname, 4, 'h', 'o', 's', 't', OptionEnd},
+		[]byte{OptionPad, 119, 1, 'f', OptionEnd})
+
+	p, err := FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pieces are joined in the order options, file, sname.
+	if search, _ := p.Options.String(119); search != "abcdef" {
+		t.Errorf("option 119 = %q, want abcdef", search)
+	}
+	if hostname, _ := p.Options.String(OptionHostname); hostname != "host" {
+		t.Errorf("hostname = %q", hostname)
+	}
+	if p.Options.Has(OptionOverload) {
+		t.Errorf("option 52 kept after reading the fields")
+	}
+	if p.File != nil || p.Hostname != nil || p.MaxSize != 0 {
+		t.Errorf("file %v, sname %v, max size %d", p.File, p.Hostname, p.MaxSize)
+	}
+
+	// Only the fields named by option 52 hold options.
+	p, err = FromBytes(overloadedRequest(OverloadFile, []byte{OptionHostname, 1, 'x', OptionEnd}, []byte("server")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.Options.Has(OptionHostname) || !bytes.HasPrefix(p.Hostname, []byte("server")) || p.File != nil {
+		t.Errorf("options %v, sname %q, file %v", p.Options, p.Hostname, p.File)
+	}
+}
+
+func TestFromBytesOverloadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		err  string
+	}{
+		{"invalid value", overloadedRequest(4, nil, nil), "invalid option overload"},
+		{"no value", overloadedRequest(0, nil, nil), "invalid option overload"},
+		{"truncated file", overloadedRequest(OverloadFile, append(bytes.Repeat([]byte{0}, 126), 12, 5), nil), "malformed overloaded options"},
+		{"truncated sname", overloadedRequest(OverloadSname, nil, append(bytes.Repeat([]byte{0}, 63), 12)), "malformed overloaded options"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := FromBytes(tt.data); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -1,8 +1,27 @@
 package packet
 
 import (
+	"bytes"
 	"encoding/binary"
 	"encoding/hex"
+	"fmt"
+	"net"
+	"pisa/util"
+)
+
+// BOOTP opcodes.
+const (
+	BootRequest uint8 = 1
+	BootReply   uint8 = 2
+)
+
+// Sizes of the fixed BOOTP fields.
+const (
+	headerLength   = 236
+	chaddrLength   = 16
+	snameLength    = 64
+	fileLength     = 128
+	minimumMessage = 300
 )
 
 // Represents a DHCP Packet
@@ -24,20 +43,85 @@ type Packet struct {
 	ClientMAC []byte
 	Hostname  []byte
 	File      []byte
-	Options   []byte
+	Options   Options
 
 	StringMAC  string
 	DHCPAction uint8
 	Payload    []byte
+
+	// Relay Agent Information (option 82), nil if no relay added it.
+	RelayInfo *RelayAgentInfo
+
+	// Largest message the receiver accepts, without IP and UDP headers.
+	//
+	// Options that don't fit into the options field are moved into the file and
+	// sname fields if they are empty (option 52). Zero means no limit, the
+	// sender sets it, packets that are read don't have one.
+	MaxSize int
+
+	// Set when the packet is received, not part of the message.
+	//
+	// Sender of the packet.
+	Source *net.UDPAddr
+	// Destination address from the IP header.
+	Destination uint32
+	// Index of the interface the packet arrived on.
+	Interface int
 }
 
-func FromBytes(data []byte) *Packet {
-	options := data[232:]
-	var action uint8
-	for i := 0; i <= len(options)-1; i++ {
-		if options[i] == byte(53) {
-			action = uint8(options[i+2])
-			break
+// Parses a DHCP packet.
+//
+// Returns an error if the data is too short, lacks the magic cookie or
+// carries malformed options, so a broken client can't crash the server.
+func FromBytes(data []byte) (*Packet, error) {
+	if len(data) < headerLength+len(util.MagicCookie) {
+		return nil, fmt.Errorf("packet too short: %d bytes", len(data))
+	}
+	if !bytes.Equal(data[236:240], util.MagicCookie) {
+		return nil, fmt.Errorf("invalid magic cookie: %v", data[236:240])
+	}
+
+	hlen := int(data[2])
+	if hlen > chaddrLength {
+		return nil, fmt.Errorf("invalid hardware address length: %d", hlen)
+	}
+
+	// The packet keeps its own copy so the read buffer can be reused.
+	data = bytes.Clone(data)
+
+	// Options start after the magic cookie.
+	options, err := DecodeOptions(data[240:])
+	if err != nil {
+		return nil, fmt.Errorf("malformed options: %w", err)
+	}
+
+	// Options that didn't fit continue in file and then sname.
+	sname, file := data[44:108], data[108:236]
+	if overload, ok := options.Get(OptionOverload); ok {
+		if len(overload) != 1 || overload[0] < OverloadFile || overload[0] > OverloadBoth {
+			return nil, fmt.Errorf("invalid option overload: %v", overload)
+		}
+		if overload[0]&OverloadFile != 0 {
+			if err := options.decodeOverloaded(file); err != nil {
+				return nil, err
+			}
+			file = nil
+		}
+		if overload[0]&OverloadSname != 0 {
+			if err := options.decodeOverloaded(sname); err != nil {
+				return nil, err
+			}
+			sname = nil
+		}
+		options.Delete(OptionOverload)
+	}
+	action, _ := options.Uint8(OptionMessageType)
+
+	var relayInfo *RelayAgentInfo
+	if data, ok := options.Get(OptionRelayAgentInfo); ok {
+		relayInfo, err = DecodeRelayAgentInfo(data)
+		if err != nil {
+			return nil, fmt.Errorf("malformed relay agent information: %w", err)
 		}
 	}
 
@@ -51,18 +135,76 @@ func FromBytes(data []byte) *Packet {
 		ElapsedSince:  binary.BigEndian.Uint16(data[8:10]),
 		Flags:         binary.BigEndian.Uint16(data[10:12]),
 
-		ClientAddress:   binary.BigEndian.Uint32(data[12:16]),
-		ServerAddress:   binary.BigEndian.Uint32(data[16:20]),
-		AssignedAddress: binary.BigEndian.Uint32(data[20:24]),
-		GatewayAddress:  binary.BigEndian.Uint32(data[24:28]),
+		ClientAddress:  binary.BigEndian.Uint32(data[12:16]),
+		YourAddress:    binary.BigEndian.Uint32(data[16:20]),
+		ServerAddress:  binary.BigEndian.Uint32(data[20:24]),
+		GatewayAddress: binary.BigEndian.Uint32(data[24:28]),
 
-		ClientMAC: data[28 : 28+uint8(data[2])], // max is 12 so 28:40
-		Hostname:  data[40:104],
-		File:      data[104:232],
-		Options:   data[232:],
+		ClientMAC: data[28 : 28+hlen], // chaddr is 16 bytes so 28:44
+		Hostname:  sname,
+		File:      file,
+		Options:   options,
 
-		StringMAC:  hex.EncodeToString(data[28 : 28+uint8(data[2])]),
+		StringMAC:  hex.EncodeToString(data[28 : 28+hlen]),
 		DHCPAction: action,
 		Payload:    data,
+		RelayInfo:  relayInfo,
+	}, nil
+}
+
+// Reports whether the packet was forwarded by a relay agent (giaddr set).
+func (p *Packet) Relayed() bool {
+	return p.GatewayAddress != 0
+}
+
+// Encodes the packet into a wire message.
+//
+// StringMAC, DHCPAction and Payload are ignored, the message type is taken from Options.
+// Returns an error if the options don't fit into MaxSize.
+//
+// Implements encoding.BinaryMarshaler.
+func (p *Packet) MarshalBinary() ([]byte, error) {
+	if len(p.TransactionID) != 4 {
+		return nil, fmt.Errorf("transaction id must be 4 bytes, got %d", len(p.TransactionID))
+	}
+	if len(p.ClientMAC) > chaddrLength {
+		return nil, fmt.Errorf("client hardware address too long: %d bytes", len(p.ClientMAC))
+	}
+	if len(p.Hostname) > snameLength {
+		return nil, fmt.Errorf("server hostname too long: %d bytes", len(p.Hostname))
+	}
+	if len(p.File) > fileLength {
+		return nil, fmt.Errorf("boot file name too long: %d bytes", len(p.File))
+	}
+	options, file, sname, err := p.layoutOptions()
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, headerLength, minimumMessage)
+	b[0] = p.Opcode
+	b[1] = p.HardwareAddressType
+	b[2] = p.HardwareAddressLength
+	b[3] = p.Hops
+	copy(b[4:8], p.TransactionID)
+	binary.BigEndian.PutUint16(b[8:10], p.ElapsedSince)
+	binary.BigEndian.PutUint16(b[10:12], p.Flags)
+
+	binary.BigEndian.PutUint32(b[12:16], p.ClientAddress)
+	binary.BigEndian.PutUint32(b[16:20], p.YourAddress)
+	binary.BigEndian.PutUint32(b[20:24], p.ServerAddress)
+	binary.BigEndian.PutUint32(b[24:28], p.GatewayAddress)
+
+	copy(b[28:44], p.ClientMAC)
+	copy(b[44:108], sname)
+	copy(b[108:236], file)
+
+	b = append(b, util.MagicCookie...)
+	b = append(b, options...)
+
+	// BOOTP relays and some clients drop messages shorter than 300 bytes.
+	if len(b) < minimumMessage {
+		b = append(b, make([]byte, minimumMessage-len(b))...)
 	}
+	return b, nil
 }
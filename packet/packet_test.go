@@ -0,0 +1,188 @@
+package packet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Returns a reply as the server builds it.
+func testReply(action uint8) *Packet {
+	p := &Packet{
+		Opcode:                BootReply,
+		HardwareAddressType:   1,
+		HardwareAddressLength: 6,
+		Hops:                  1,
+		TransactionID:         []byte{0xde, 0xad, 0xbe, 0xef},
+		ElapsedSince:          3,
+		Flags:                 0x8000,
+		ClientAddress:         0,
+		YourAddress:           0x0a000064,
+		ServerAddress:         0x0a000001,
+		GatewayAddress:        0x0a000002,
+		ClientMAC:             []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55},
+	}
+	p.Options.SetUint8(OptionMessageType, action)
+	p.Options.SetIP(OptionServerIdentifier, []byte{10, 0, 0, 1})
+	if action != DHCPNak {
+		p.Options.SetUint32(OptionLeaseTime, 3600)
+		p.Options.SetIP(OptionSubnetMask, []byte{255, 255, 255, 0})
+		p.Options.SetIPs(OptionRouter, [][]byte{{10, 0, 0, 1}})
+	} else {
+		p.YourAddress = 0
+		p.Options.SetString(OptionMessage, "address not available")
+	}
+	return p
+}
+
+func TestMarshalBinaryRoundTrip(t *testing.T) {
+	for _, action := range []uint8{DHCPOffer, DHCPAck, DHCPNak} {
+		reply := testReply(action)
+		data, err := reply.MarshalBinary()
+		if err != nil {
+			t.Fatalf("type %d: %v", action, err)
+		}
+
+		if len(data) < minimumMessage {
+			t.Errorf("type %d: %d bytes, want at least %d", action, len(data), minimumMessage)
+		}
+		if !bytes.Equal(data[headerLength:headerLength+4], []byte{99, 130, 83, 99}) {
+			t.Errorf("type %d: magic cookie not at byte %d", action, headerLength)
+		}
+
+		p, err := FromBytes(data)
+		if err != nil {
+			t.Fatalf("type %d: parsing back: %v", action, err)
+		}
+		if p.Opcode != reply.Opcode || p.HardwareAddressType != reply.HardwareAddressType ||
+			p.HardwareAddressLength != reply.HardwareAddressLength || p.Hops != reply.Hops {
+			t.Errorf("type %d: op/htype/hlen/hops = %d/%d/%d/%d", action, p.Opcode, p.HardwareAddressType, p.HardwareAddressLength, p.Hops)
+		}
+		if !bytes.Equal(p.TransactionID, reply.TransactionID) || p.ElapsedSince != reply.ElapsedSince || p.Flags != reply.Flags {
+			t.Errorf("type %d: xid/secs/flags = %x/%d/%x", action, p.TransactionID, p.ElapsedSince, p.Flags)
+		}
+		if p.ClientAddress != reply.ClientAddress || p.YourAddress != reply.YourAddress ||
+			p.ServerAddress != reply.ServerAddress || p.GatewayAddress != reply.GatewayAddress {
+			t.Errorf("type %d: ciaddr/yiaddr/siaddr/giaddr = %x/%x/%x/%x", action,
+				p.ClientAddress, p.YourAddress, p.ServerAddress, p.GatewayAddress)
+		}
+		if !bytes.Equal(p.ClientMAC, reply.ClientMAC) || p.StringMAC != "001122334455" {
+			t.Errorf("type %d: chaddr = %x", action, p.ClientMAC)
+		}
+		if p.DHCPAction != action {
+			t.Errorf("type %d: message type = %d", action, p.DHCPAction)
+		}
+		if !equalOptions(p.Options, reply.Options) {
+			t.Errorf("type %d: options = %v, want %v", action, p.Options, reply.Options)
+		}
+	}
+}
+
+func TestMarshalBinaryFields(t *testing.T) {
+	reply := testReply(DHCPAck)
+	reply.Hostname = []byte("server")
+	reply.File = []byte("pxelinux.0")
+	reply.Options.Set(OptionDNS, bytes.Repeat([]byte{1}, 200))
+
+	data, err := reply.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Large enough to need no padding.
+	if len(data) <= minimumMessage {
+		t.Errorf("%d bytes, expected more than %d", len(data), minimumMessage)
+	}
+	if !bytes.Equal(data[44:50], []byte("server")) || data[50] != 0 {
+		t.Errorf("sname = %q", data[44:108])
+	}
+	if !bytes.Equal(data[108:118], []byte("pxelinux.0")) || data[118] != 0 {
+		t.Errorf("file = %q", data[108:236])
+	}
+
+	p, err := FromBytes(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Hostname) != snameLength || len(p.File) != fileLength {
+		t.Errorf("sname and file are %d and %d bytes", len(p.Hostname), len(p.File))
+	}
+}
+
+func TestMarshalBinaryErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(p *Packet)
+		err    string
+	}{
+		{"short xid", func(p *Packet) { p.TransactionID = []byte{1, 2, 3} }, "transaction id must be 4 bytes"},
+		{"long xid", func(p *Packet) { p.TransactionID = []byte{1, 2, 3, 4, 5} }, "transaction id must be 4 bytes"},
+		{"chaddr", func(p *Packet) { p.ClientMAC = make([]byte, 17) }, "client hardware address too long"},
+		{"sname", func(p *Packet) { p.Hostname = make([]byte, 65) }, "server hostname too long"},
+		{"file", func(p *Packet) { p.File = make([]byte, 129) }, "boot file name too long"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := testReply(DHCPOffer)
+			tt.modify(p)
+			if _, err := p.MarshalBinary(); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+
+	// The largest valid fields are accepted.
+	p := testReply(DHCPOffer)
+	p.ClientMAC, p.Hostname, p.File = make([]byte, 16), make([]byte, 64), make([]byte, 128)
+	if _, err := p.MarshalBinary(); err != nil {
+		t.Errorf("fields at their maximum length: %v", err)
+	}
+}
+
+// Returns a DISCOVER as a client sends it.
+func testDiscover() []byte {
+	b := make([]byte, headerLength, minimumMessage)
+	b[0], b[1], b[2] = BootRequest, 1, 6
+	copy(b[4:8], []byte{1, 2, 3, 4})
+	copy(b[28:34], []byte{0, 0x11, 0x22, 0x33, 0x44, 0x55})
+	b = append(b, 99, 130, 83, 99)
+	b = append(b, OptionMessageType, 1, DHCPDiscover)
+	b = append(b, OptionParameterRequestList, 3, 1, 3, 6)
+	b = append(b, OptionEnd)
+	return append(b, make([]byte, minimumMessage-len(b))...)
+}
+
+func FuzzFromBytes(f *testing.F) {
+	discover := testDiscover()
+	f.Add(discover)
+
+	// Truncated inside the header, inside the options and right after the cookie.
+	f.Add(discover[:100])
+	f.Add(discover[:headerLength+6])
+	f.Add(discover[:headerLength+4])
+
+	badCookie := bytes.Clone(discover)
+	badCookie[headerLength] = 0
+	f.Add(badCookie)
+
+	longHlen := bytes.Clone(discover)
+	longHlen[2] = 17
+	f.Add(longHlen)
+
+	// Options continue in file and sname.
+	overloaded := bytes.Clone(discover[:headerLength+4])
+	overloaded = append(overloaded, OptionOverload, 1, OverloadBoth, OptionMessageType, 1, DHCPRequest, OptionEnd)
+	copy(overloaded[108:], []byte{OptionHostname, 4, 'h', 'o', 's', 't', OptionEnd})
+	copy(overloaded[44:], []byte{OptionRequestedAddress, 4, 10, 0, 0, 5, OptionEnd})
+	f.Add(overloaded)
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		p, err := FromBytes(data)
+		if err != nil {
+			return
+		}
+		if _, err := p.MarshalBinary(); err != nil {
+			t.Fatalf("parsed packet doesn't marshal: %v", err)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package packet
+
+import "fmt"
+
+// Relay Agent Information option (RFC 3046).
+const OptionRelayAgentInfo uint8 = 82
+
+// Sub-options of the Relay Agent Information option.
+const (
+	SubOptionCircuitID uint8 = 1
+	SubOptionRemoteID  uint8 = 2
+)
+
+// Relay Agent Information added by a relay, usually a switch.
+type RelayAgentInfo struct {
+	// Port or VLAN the client is connected to.
+	CircuitID []byte
+	// Device the relay agent runs on.
+	RemoteID []byte
+
+	// Option exactly as received, replies have to echo it verbatim.
+	Raw []byte
+}
+
+// Decodes the data of option 82.
+func DecodeRelayAgentInfo(data []byte) (*RelayAgentInfo, error) {
+	info := &RelayAgentInfo{Raw: data}
+	for i := 0; i < len(data); {
+		if i+1 >= len(data) {
+			return nil, fmt.Errorf("relay agent sub-option %d: missing length", data[i])
+		}
+		code, length := data[i], int(data[i+1])
+		if i+2+length > len(data) {
+			return nil, fmt.Errorf("relay agent sub-option %d: length %d exceeds remaining %d bytes", code, length, len(data)-i-2)
+		}
+
+		value := data[i+2 : i+2+length]
+		switch code {
+		case SubOptionCircuitID:
+			info.CircuitID = value
+		case SubOptionRemoteID:
+			info.RemoteID = value
+		}
+		i += 2 + length
+	}
+	return info, nil
+}
+
+// Encodes the Circuit-ID and Remote-ID sub-options.
+func (r *RelayAgentInfo) Encode() []byte {
+	var b []byte
+	if len(r.CircuitID) > 0 {
+		b = append(b, SubOptionCircuitID, byte(len(r.CircuitID)))
+		b = append(b, r.CircuitID...)
+	}
+	if len(r.RemoteID) > 0 {
+		b = append(b, SubOptionRemoteID, byte(len(r.RemoteID)))
+		b = append(b, r.RemoteID...)
+	}
+	return b
+}
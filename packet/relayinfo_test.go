@@ -0,0 +1,87 @@
+package packet
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDecodeRelayAgentInfo(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    []byte
+		circuit string
+		remote  string
+		err     string
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:    "circuit and remote",
+			data:    []byte{1, 6, 'e', 't', 'h', '1', '/', '1', 2, 3, 's', 'w', '1'},
+			circuit: "eth1/1",
+			remote:  "sw1",
+		},
+		{
+			name:   "unknown sub-options are skipped",
+			data:   []byte{9, 2, 0xab, 0xcd, 2, 2, 's', 'w', 5, 0},
+			remote: "sw",
+		},
+		{
+			name:   "empty sub-option",
+			data:   []byte{1, 0, 2, 1, 'r'},
+			remote: "r",
+		},
+		{
+			name: "missing length",
+			data: []byte{1, 1, 'p', 2},
+			err:  "relay agent sub-option 2: missing length",
+		},
+		{
+			name: "length beyond data",
+			data: []byte{1, 4, 'p', '1'},
+			err:  "relay agent sub-option 1: length 4 exceeds remaining 2 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			info, err := DecodeRelayAgentInfo(tt.data)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("error = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if string(info.CircuitID) != tt.circuit || string(info.RemoteID) != tt.remote {
+				t.Errorf("circuit %q, remote %q, want %q, %q", info.CircuitID, info.RemoteID, tt.circuit, tt.remote)
+			}
+			// Replies echo the option as it was received.
+			if !bytes.Equal(info.Raw, tt.data) {
+				t.Errorf("raw = %v, want %v", info.Raw, tt.data)
+			}
+		})
+	}
+}
+
+func TestRelayAgentInfoEncode(t *testing.T) {
+	info := &RelayAgentInfo{CircuitID: []byte("eth1"), RemoteID: []byte("sw1")}
+	want := []byte{1, 4, 'e', 't', 'h', '1', 2, 3, 's', 'w', '1'}
+	data := info.Encode()
+	if !bytes.Equal(data, want) {
+		t.Fatalf("encoded = %v, want %v", data, want)
+	}
+	decoded, err := DecodeRelayAgentInfo(data)
+	if err != nil || string(decoded.CircuitID) != "eth1" || string(decoded.RemoteID) != "sw1" {
+		t.Errorf("decoded = %+v, %v", decoded, err)
+	}
+
+	// Empty IDs are left out.
+	if data := (&RelayAgentInfo{RemoteID: []byte("sw1")}).Encode(); !bytes.Equal(data, want[6:]) {
+		t.Errorf("encoded without circuit = %v", data)
+	}
+}
@@ -3,6 +3,7 @@ package util
 import (
 	"encoding/binary"
 	"log"
+	"net"
 	"regexp"
 	"strconv"
 	"strings"
@@ -42,8 +43,8 @@ func Uint16Bytes(u uint16) []byte {
 
 // Function to test a address
 func CheckAddress(addr string) bool {
-	m, _ := regexp.MatchString(`\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}`, addr)
-	return m
+	m, _ := regexp.MatchString(`^\d{1,3}\.\d{1,3}\.\d{1,3}\.\d{1,3}$`, addr)
+	return m && net.ParseIP(addr) != nil
 }
 
 // Converts a address string into a uint32.
@@ -58,6 +59,12 @@ func AddressIntoUint32(addr string) uint32 {
 	return binary.BigEndian.Uint32(b)
 }
 
+// Converts a uint32 into a address string.
+func Uint32IntoAddress(u uint32) string {
+	b := Uint32Bytes(u)
+	return strconv.Itoa(int(b[0])) + "." + strconv.Itoa(int(b[1])) + "." + strconv.Itoa(int(b[2])) + "." + strconv.Itoa(int(b[3]))
+}
+
 // Converts a address string into a byte array.
 func AddressIntoBytearray(addr string) []byte {
 	octets := strings.Split(addr, ".")